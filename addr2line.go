@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+const addr2lineCommand = "addr2line"
+
+// the input and flags shared by the commands that look up addresses
+type symbolizerArgs struct {
+	input       string
+	inputFormat string
+	section     string
+	base        uint32
+	// otherwise the base is where the text runs in IMEM
+	hasBase bool
+}
+
+func symbolizerUsage() string {
+	return `	-b    address the start of the text is loaded at, defaults to 0x04001000
+	      or the IMEM address of the overlay picked with -j
+	      use 0x04001080 when loaded after rspboot
+	-j    section of an object to look up addresses in, defaults to .text
+	-i    assembler that produced the input when it isn't an object, ` + autoFrontend + ` or one of ` + strings.Join(frontendNames(), ", ")
+}
+
+// parses -b, -j and -i, other flags go to parseFlag which returns false
+// for ones it doesn't know, arguments after the input are returned
+func parseSymbolizerArgs(args []string, parseFlag func(flag string, value string) (bool, error)) (*symbolizerArgs, []string, error) {
+	var result = symbolizerArgs{"", autoFrontend, ".text", imemAddress, false}
+	var rest []string = nil
+
+	for i := 0; i < len(args); i++ {
+		var arg = args[i]
+
+		if arg[0] == '-' {
+			if i+1 >= len(args) {
+				return nil, nil, errors.New(arg + " flag requires a parameter")
+			}
+
+			if arg == "-b" {
+				base, err := parseMaybeHex(args[i+1], 64)
+
+				if err != nil {
+					return nil, nil, errors.New("-b should be an address such as 0x04001000")
+				}
+
+				result.base = uint32(base)
+				result.hasBase = true
+			} else if arg == "-j" {
+				result.section = args[i+1]
+			} else if arg == "-i" {
+				result.inputFormat = args[i+1]
+			} else {
+				known, err := parseFlag(arg, args[i+1])
+
+				if err != nil {
+					return nil, nil, err
+				} else if !known {
+					return nil, nil, errors.New("Unknown flag " + arg)
+				}
+			}
+
+			i++
+		} else if result.input == "" {
+			result.input = arg
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+
+	if result.input == "" {
+		return nil, nil, errors.New("An input file is required")
+	}
+
+	return &result, rest, nil
+}
+
+func noExtraFlags(flag string, value string) (bool, error) {
+	return false, nil
+}
+
+func (args *symbolizerArgs) load() (*symbolizer, error) {
+	lookup, err := loadSymbolizer(args.input, args.section, args.inputFormat)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !args.hasBase {
+		args.base = imemAddress + lookup.loadAddress
+	}
+
+	return lookup, nil
+}
+
+type addr2lineArgs struct {
+	symbolizer *symbolizerArgs
+	addresses  []string
+}
+
+func parseAddr2lineArgs(args []string) (*addr2lineArgs, error) {
+	if len(args) == 0 {
+		return nil, errors.New(`rsp2dwarf addr2line [-b base] [-j section] [-i assembler] input [address...]
+` + symbolizerUsage() + `
+	addresses are read from standard in when none are given`)
+	}
+
+	symbolizer, addresses, err := parseSymbolizerArgs(args, noExtraFlags)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &addr2lineArgs{symbolizer, addresses}, nil
+}
+
+// a macro or function inlined into the code, offsets are from the start of the text
+type inlineRange struct {
+	start    uint32
+	end      uint32
+	depth    int
+	name     string
+	callFile string
+	callLine int
+}
+
+type addressFrame struct {
+	function string
+	filename string
+	line     int
+}
+
+// looks up offsets from the start of the text
+type symbolizer struct {
+	size    uint32
+	symbols SortSymbolsByValue
+	lines   sortLinesByAddress
+	inlines []inlineRange
+	// IMEM offset the text runs at, only known for overlays
+	loadAddress uint32
+}
+
+func newSymbolizer(size uint32, symbols []SymbolDef, lines []dwarf.InstructionEntry, inlines []inlineRange) *symbolizer {
+	var result = &symbolizer{size, append(SortSymbolsByValue(nil), symbols...), append(sortLinesByAddress(nil), lines...), inlines, 0}
+	sort.Stable(result.symbols)
+	sort.Stable(result.lines)
+	return result
+}
+
+// the closest symbol at or before offset, preferring entry points over local labels
+func (lookup *symbolizer) symbolAt(offset uint32) *SymbolDef {
+	var best *SymbolDef = nil
+
+	for index := range lookup.symbols {
+		var symbol = &lookup.symbols[index]
+
+		if symbol.Value > offset {
+			break
+		}
+
+		if best == nil || symbol.Kind != SymbolLocalLabel || best.Kind == SymbolLocalLabel {
+			best = symbol
+		}
+	}
+
+	return best
+}
+
+func (lookup *symbolizer) function(offset uint32) string {
+	var best = lookup.symbolAt(offset)
+
+	if best == nil {
+		return "??"
+	} else if best.Value == offset {
+		return best.Name
+	}
+
+	return fmt.Sprintf("%s+0x%X", best.Name, offset-best.Value)
+}
+
+// the innermost frame comes first followed by each place it was inlined
+func (lookup *symbolizer) frames(offset uint32) []addressFrame {
+	var filename = "??"
+	var line = 0
+
+	var index = sort.Search(len(lookup.lines), func(i int) bool {
+		return lookup.lines[i].Address() > int(offset)
+	}) - 1
+
+	if index >= 0 {
+		filename = lookup.lines[index].Filename()
+		line = lookup.lines[index].Line()
+	}
+
+	var chain []inlineRange = nil
+
+	for _, inline := range lookup.inlines {
+		if offset >= inline.start && offset < inline.end {
+			chain = append(chain, inline)
+		}
+	}
+
+	sort.SliceStable(chain, func(i, j int) bool {
+		return chain[i].depth > chain[j].depth
+	})
+
+	var result []addressFrame = nil
+
+	for _, inline := range chain {
+		result = append(result, addressFrame{inline.name, filename, line})
+		filename = inline.callFile
+		line = inline.callLine
+	}
+
+	return append(result, addressFrame{lookup.function(offset), filename, line})
+}
+
+func loadInputSymbolizer(filename string, inputFormat string) (*symbolizer, error) {
+	frontend, err := findFrontend(inputFormat, filename, DebugAll)
+
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := frontend.Load(filename, DebugAll, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var symbols []SymbolDef = nil
+
+	if input.Symbols != nil {
+		symbols = input.Symbols.Instructions
+	}
+
+	return newSymbolizer(uint32(len(input.Text)), symbols, input.Lines, nil), nil
+}
+
+// the section each relocation in a rel section points at by offset
+func readRelocationSections(elfFile *elf.ElfFile, name string, symbols []elf.ElfSymbol) map[int]int {
+	var result = make(map[int]int)
+	var relocations = sectionData(elfFile, name)
+	var byteOrder = elfFile.Header.ByteOrder()
+
+	for offset := 0; offset+8 <= len(relocations); offset += 8 {
+		var symbolIndex = int(byteOrder.Uint32(relocations[offset+4:]) >> 8)
+
+		if symbolIndex < len(symbols) {
+			result[int(byteOrder.Uint32(relocations[offset:]))] = int(symbols[symbolIndex].SHIndex)
+		}
+	}
+
+	return result
+}
+
+func collectInlines(entry *dwarf.DebugEntry, depth int, byOffset map[int]*dwarf.DebugEntry, lineFile func(file uint64) string, textAddress uint32, result *[]inlineRange) {
+	for _, child := range entry.Children {
+		var childDepth = depth
+
+		if child.Tag == dwarf.DW_TAG_inlined_subroutine {
+			low, high, ok := child.PCRange()
+			var name = child.String(dwarf.DW_AT_name)
+			origin, hasOrigin := child.Reference(dwarf.DW_AT_abstract_origin)
+
+			if hasOrigin && byOffset[origin] != nil {
+				name = byOffset[origin].String(dwarf.DW_AT_name)
+			}
+
+			callFile, _ := child.Number(dwarf.DW_AT_call_file)
+			callLine, _ := child.Number(dwarf.DW_AT_call_line)
+			childDepth++
+
+			if ok {
+				*result = append(*result, inlineRange{uint32(low) - textAddress, uint32(high) - textAddress, childDepth, name, lineFile(callFile), int(callLine)})
+			}
+		}
+
+		collectInlines(child, childDepth, byOffset, lineFile, textAddress, result)
+	}
+}
+
+func indexDebugEntries(entries []*dwarf.DebugEntry, result map[int]*dwarf.DebugEntry) {
+	for _, entry := range entries {
+		result[entry.Offset] = entry
+		indexDebugEntries(entry.Children, result)
+	}
+}
+
+// objects written by rsp2dwarf have a unit per text section with addresses
+// relocated against it, linked objects have the section at its address
+func findSectionUnits(elfFile *elf.ElfFile, units []*dwarf.DebugEntry, elfSymbols []elf.ElfSymbol, textIndex int) []*dwarf.DebugEntry {
+	var text = &elfFile.Sections[textIndex]
+	var isLinked = elfFile.Header.Type() == elf.ET_EXEC
+	var relocated = readRelocationSections(elfFile, ".rel.debug_info", elfSymbols)
+	var result []*dwarf.DebugEntry = nil
+
+	for _, unit := range units {
+		lowPC, ok := unit.AddressField(dwarf.DW_AT_low_pc)
+
+		if !ok {
+			continue
+		} else if isLinked && (lowPC.Value < uint64(text.Address) || lowPC.Value >= uint64(text.Address)+uint64(len(text.Data))) {
+			continue
+		} else if !isLinked && relocated[lowPC.Offset] != textIndex {
+			continue
+		}
+
+		result = append(result, unit)
+	}
+
+	return result
+}
+
+func loadObjectSymbolizer(filename string, sectionName string) (*symbolizer, error) {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	elfFile, err := elf.ParseElf(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var textIndex = elfFile.FindSectionIndex(sectionName)
+
+	if textIndex == -1 {
+		return nil, errors.New(filename + " has no " + sectionName + " section")
+	}
+
+	var text = &elfFile.Sections[textIndex]
+	var byteOrder = elfFile.Header.ByteOrder()
+
+	elfSymbols, err := elfFile.ReadSymbols()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var symbols []SymbolDef = nil
+	var linkSymbols = findLinkSymbols(elfSymbols, text.Address, uint32(len(text.Data)))
+
+	for _, symbol := range elfSymbols {
+		if int(symbol.SHIndex) != textIndex || symbol.Name == "" || symbol.Type() == elf.STT_SECTION || linkSymbols[symbol.Name] {
+			continue
+		}
+
+		var kind = SymbolEntryPoint
+
+		if symbol.Binding() == elf.STB_LOCAL {
+			kind = SymbolLocalLabel
+		}
+
+		symbols = append(symbols, SymbolDef{symbol.Name, symbol.Value - text.Address, 0, kind, "", 0})
+	}
+
+	units, err := dwarf.ParseDebugInfo(dwarf.DebugInfoSections{
+		Info:    sectionData(elfFile, ".debug_info"),
+		Abbrev:  sectionData(elfFile, ".debug_abbrev"),
+		Str:     sectionData(elfFile, ".debug_str"),
+		LineStr: sectionData(elfFile, ".debug_line_str"),
+	}, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var debugLine = sectionData(elfFile, ".debug_line")
+	var debugLineStr = sectionData(elfFile, ".debug_line_str")
+	var byOffset = make(map[int]*dwarf.DebugEntry)
+	var lines []dwarf.InstructionEntry = nil
+	var inlines []inlineRange = nil
+
+	indexDebugEntries(units, byOffset)
+
+	for _, unit := range findSectionUnits(elfFile, units, elfSymbols, textIndex) {
+		stmtList, hasLines := unit.Number(dwarf.DW_AT_stmt_list)
+
+		var lineFile = func(file uint64) string {
+			return dwarf.LineProgramFilename(debugLine, debugLineStr, int(stmtList), file, byteOrder)
+		}
+
+		collectInlines(unit, 0, byOffset, lineFile, text.Address, &inlines)
+
+		if !hasLines {
+			continue
+		}
+
+		unitLines, err := dwarf.ParseDebugLinesAt(debugLine, debugLineStr, int(stmtList), byteOrder)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range unitLines {
+			lines = append(lines, line.WithAddress(line.Address()-int(text.Address)))
+		}
+	}
+
+	var result = newSymbolizer(uint32(len(text.Data)), symbols, lines, inlines)
+
+	if isOverlaySection(sectionName) {
+		result.loadAddress, _ = findOverlayLoadAddress(elfFile, elfSymbols, sectionName)
+	}
+
+	return result, nil
+}
+
+// the PC is 12 bits so IMEM offsets, 0x1000 based addresses and
+// 0x04001000 based addresses all end up at the same place
+func (lookup *symbolizer) offsetOf(address uint32, base uint32) (uint32, bool) {
+	var offset = (address - base) & (imemSize - 1)
+	return offset, offset < lookup.size
+}
+
+// an object is read through its debug info, anything else through the frontends
+func loadSymbolizer(input string, section string, inputFormat string) (*symbolizer, error) {
+	if (elfFrontend{}).Detect(input) {
+		return loadObjectSymbolizer(input, section)
+	}
+
+	return loadInputSymbolizer(input, inputFormat)
+}
+
+func writeAddressLookup(output io.Writer, lookup *symbolizer, address string, base uint32) error {
+	value, err := parseMaybeHex(address, 64)
+
+	if err != nil {
+		return errors.New("Invalid address " + address)
+	}
+
+	offset, ok := lookup.offsetOf(uint32(value), base)
+
+	if !ok {
+		_, err = fmt.Fprintf(output, "0x%08X ?? ??:0\n", base+offset)
+		return err
+	}
+
+	for index, frame := range lookup.frames(offset) {
+		if index == 0 {
+			_, err = fmt.Fprintf(output, "0x%08X %s %s:%d\n", base+offset, frame.function, frame.filename, frame.line)
+		} else {
+			_, err = fmt.Fprintf(output, "  (inlined by) %s %s:%d\n", frame.function, frame.filename, frame.line)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runAddr2line(args []string) error {
+	parsed, err := parseAddr2lineArgs(args)
+
+	if err != nil {
+		return err
+	}
+
+	lookup, err := parsed.symbolizer.load()
+
+	if err != nil {
+		return err
+	}
+
+	if len(parsed.addresses) > 0 {
+		for _, address := range parsed.addresses {
+			err = writeAddressLookup(os.Stdout, lookup, address, parsed.symbolizer.base)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// answer each address as it arrives so it can be used from a pipe
+	var scanner = bufio.NewScanner(os.Stdin)
+	scanner.Split(bufio.ScanWords)
+
+	for scanner.Scan() {
+		err = writeAddressLookup(os.Stdout, lookup, scanner.Text(), parsed.symbolizer.base)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
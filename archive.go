@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+func isArchiveOutput(filename string) bool {
+	return strings.HasSuffix(filename, ".a")
+}
+
+func findDefinedSymbols(data []byte) ([]string, error) {
+	elfFile, err := elf.ParseElf(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, err := elfFile.ReadSymbols()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string = nil
+
+	for _, symbol := range symbols {
+		if symbol.Binding() != elf.STB_LOCAL && symbol.SHIndex != elf.SHN_UNDEF {
+			result = append(result, symbol.Name)
+		}
+	}
+
+	return result, nil
+}
+
+// each microcode becomes its own object in the archive
+func writeArchive(microcodes []*Microcode, args *commandLineArgs, profile *toolchainProfile) error {
+	var members []elf.ArchiveMember = nil
+
+	for _, microcode := range microcodes {
+		var single = &Microcode{microcode.Name, microcode.Input, ".text", ".data", microcode.TaskFormat, microcode.LoadAddress}
+
+		elfFile, err := buildElf([]*Microcode{single}, args.compDir, args.includeDebug, args.debugFormat, profile)
+
+		if err != nil {
+			return err
+		}
+
+		elfFile.DebugCompression = args.compression
+
+		data, err := elf.SerializeToBytes(elfFile)
+
+		if err != nil {
+			return err
+		}
+
+		symbols, err := findDefinedSymbols(data)
+
+		if err != nil {
+			return err
+		}
+
+		members = append(members, elf.ArchiveMember{
+			Name:    microcode.Name + ".o",
+			Data:    data,
+			Symbols: symbols,
+		})
+	}
+
+	var archive bytes.Buffer
+
+	err := elf.WriteArchive(&archive, members)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(args.output, archive.Bytes(), 0664)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+const dmemSize = 0x1000
+
+// the RSP DMA engine moves multiples of 8 bytes
+const dmaAlignment = 8
+
+// fails when a microcode can't be loaded by the hardware,
+// textOffset is where the text starts in IMEM such as 0x80 after rspboot
+func checkCapacity(filenames []string, inputs []*MicrocodeInput, textOffset uint32) error {
+	var problems []string = nil
+
+	for index, input := range inputs {
+		var filename = filenames[index]
+
+		if textOffset+uint32(len(input.Text)) > imemSize {
+			problems = append(problems, fmt.Sprintf("%s: text is 0x%X bytes but only 0x%X fit in IMEM after 0x%X", filename, len(input.Text), imemSize-textOffset, textOffset))
+		}
+
+		if len(input.Data) > dmemSize {
+			problems = append(problems, fmt.Sprintf("%s: data is 0x%X bytes but only 0x%X fit in DMEM", filename, len(input.Data), dmemSize))
+		}
+
+		if len(input.Data)%dmaAlignment != 0 {
+			problems = append(problems, fmt.Sprintf("%s: data is 0x%X bytes, DMA needs a multiple of %d", filename, len(input.Data), dmaAlignment))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
+
+func percentOf(used int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(used) * 100 / float64(total)
+}
+
+func writeSegmentUsage(output *bytes.Buffer, name string, used int, total int) {
+	fmt.Fprintf(output, "  %-24s 0x%04X  0x%04X  0x%04X  %5.1f%%\n", name, used, total-used, total, percentOf(used, total))
+}
+
+func writeSymbolSizes(output *bytes.Buffer, heading string, symbols []SymbolDef) {
+	var listed []SymbolDef = nil
+
+	for _, symbol := range symbols {
+		if symbol.AliasOf == "" && symbol.Kind != SymbolLocalLabel {
+			listed = append(listed, symbol)
+		}
+	}
+
+	if len(listed) == 0 {
+		return
+	}
+
+	fmt.Fprintf(output, "\n  %-24s size\n", heading)
+
+	for _, symbol := range listed {
+		fmt.Fprintf(output, "  %-24s 0x%04X\n", symbol.Name, symbol.Size)
+	}
+}
+
+func writeBudgetReport(filename string, microcodes []*Microcode, textOffset uint32) error {
+	var output bytes.Buffer
+
+	for index, microcode := range microcodes {
+		var input = microcode.Input
+
+		if index > 0 {
+			output.WriteString("\n")
+		}
+
+		fmt.Fprintf(&output, "%s\n  %-24s used    free    size\n", microcode.Name, "segment")
+		writeSegmentUsage(&output, "IMEM", len(input.Text), imemSize-int(textOffset))
+		writeSegmentUsage(&output, "DMEM", len(input.Data), dmemSize)
+
+		for _, overlay := range input.Overlays {
+			writeSegmentUsage(&output, fmt.Sprintf("%s at 0x%03X", overlay.Name, overlay.LoadAddress), len(overlay.Input.Text), imemSize-int(overlay.LoadAddress))
+		}
+
+		if input.Symbols != nil {
+			writeSymbolSizes(&output, "function", input.Symbols.Instructions)
+			writeSymbolSizes(&output, "data", input.Symbols.Data)
+		}
+
+		for _, overlay := range input.Overlays {
+			if overlay.Input.Symbols != nil {
+				writeSymbolSizes(&output, overlay.Name+" function", overlay.Input.Symbols.Instructions)
+			}
+		}
+	}
+
+	if filename == "-" {
+		_, err := os.Stdout.Write(output.Bytes())
+		return err
+	}
+
+	return ioutil.WriteFile(filename, output.Bytes(), 0664)
+}
+
+// the most bytes each part of a microcode or overlay may use
+type budget struct {
+	Imem    *int           `json:"imem"`
+	Dmem    *int           `json:"dmem"`
+	Symbols map[string]int `json:"symbols"`
+}
+
+func checkSymbolBudget(owner string, limits map[string]int, symbols *SymbolTable, problems *[]string) {
+	var sizes = make(map[string]uint32)
+
+	if symbols != nil {
+		for _, group := range [][]SymbolDef{symbols.Instructions, symbols.Data} {
+			for _, symbol := range group {
+				sizes[symbol.Name] = symbol.Size
+			}
+		}
+	}
+
+	var names []string = nil
+
+	for name := range limits {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		size, ok := sizes[name]
+
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s has no symbol %s to check against its budget", owner, name))
+		} else if int(size) > limits[name] {
+			*problems = append(*problems, fmt.Sprintf("%s %s is 0x%X bytes, over its budget of 0x%X", owner, name, size, limits[name]))
+		}
+	}
+}
+
+func checkLimit(owner string, segment string, used int, limit *int, problems *[]string) {
+	if limit != nil && used > *limit {
+		*problems = append(*problems, fmt.Sprintf("%s %s uses 0x%X bytes, over its budget of 0x%X", owner, segment, used, *limit))
+	}
+}
+
+// the budget file maps microcode and overlay names to their limits
+// { "microcode": { "imem": 3968, "dmem": 2048, "symbols": { "main": 256 } } }
+func checkBudget(filename string, microcodes []*Microcode) error {
+	data, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return err
+	}
+
+	var budgets map[string]budget
+
+	err = json.Unmarshal(data, &budgets)
+
+	if err != nil {
+		return errors.New(filename + ": " + err.Error())
+	}
+
+	var problems []string = nil
+	var checked = make(map[string]bool)
+
+	for _, microcode := range microcodes {
+		limits, ok := budgets[microcode.Name]
+
+		if ok {
+			checked[microcode.Name] = true
+			checkLimit(microcode.Name, "IMEM", len(microcode.Input.Text), limits.Imem, &problems)
+			checkLimit(microcode.Name, "DMEM", len(microcode.Input.Data), limits.Dmem, &problems)
+			checkSymbolBudget(microcode.Name, limits.Symbols, microcode.Input.Symbols, &problems)
+		}
+
+		for _, overlay := range microcode.Input.Overlays {
+			limits, ok := budgets[overlay.Name]
+
+			if ok {
+				checked[overlay.Name] = true
+				checkLimit(overlay.Name, "IMEM", len(overlay.Input.Text), limits.Imem, &problems)
+				checkLimit(overlay.Name, "DMEM", 0, limits.Dmem, &problems)
+				checkSymbolBudget(overlay.Name, limits.Symbols, overlay.Input.Symbols, &problems)
+			}
+		}
+	}
+
+	var names []string = nil
+
+	for name := range budgets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !checked[name] {
+			problems = append(problems, fmt.Sprintf("%s: there is no microcode or overlay named %s", filename, name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Over budget\n" + strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/disasm"
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+const callKindCall = "call"
+const callKindTail = "tail"
+const callKindIndirect = "indirect"
+
+// a routine starts at an entry point and runs until the next one
+type routine struct {
+	Name string `json:"name"`
+	// offset from the start of IMEM
+	Address uint32 `json:"address"`
+	Size    uint32 `json:"size"`
+	Dead    bool   `json:"dead"`
+}
+
+type callSite struct {
+	Caller string `json:"caller"`
+	// empty for calls through a register
+	Callee string `json:"callee,omitempty"`
+	// offset from the start of IMEM of the call, jump or branch
+	Address uint32 `json:"address"`
+	Kind    string `json:"kind"`
+}
+
+// the routines and calls in a microcode or one of its overlays
+type callGraph struct {
+	Name        string     `json:"name"`
+	OverlayOf   string     `json:"overlayOf,omitempty"`
+	LoadAddress uint32     `json:"loadAddress"`
+	Routines    []routine  `json:"routines"`
+	Calls       []callSite `json:"calls"`
+}
+
+func (graph *callGraph) routineAt(address uint32) *routine {
+	for index := range graph.Routines {
+		var routine = &graph.Routines[index]
+
+		if address >= routine.Address && address < routine.Address+routine.Size {
+			return routine
+		}
+	}
+
+	return nil
+}
+
+func (graph *callGraph) routineNamed(name string) *routine {
+	for index := range graph.Routines {
+		if graph.Routines[index].Name == name {
+			return &graph.Routines[index]
+		}
+	}
+
+	return nil
+}
+
+func findRoutines(input *MicrocodeInput, loadAddress uint32) []routine {
+	var result []routine = nil
+
+	if input.Symbols == nil {
+		return nil
+	}
+
+	var entryPoints SortSymbolsByValue = input.Symbols.EntryPoints()
+	sort.Stable(entryPoints)
+
+	for _, symbol := range entryPoints {
+		result = append(result, routine{symbol.Name, loadAddress + symbol.Value, symbol.Size, false})
+	}
+
+	return result
+}
+
+// a routine that doesn't end with a jump or break keeps
+// running into the one after it
+func fallsThrough(text []byte, loadAddress uint32, routine *routine) bool {
+	var end = int(routine.Address-loadAddress+routine.Size) &^ 3
+
+	for _, offset := range []int{end - 8, end - 4} {
+		if offset < 0 || offset+4 > len(text) {
+			continue
+		}
+
+		var instruction = disasm.Decode(binary.BigEndian.Uint32(text[offset:]), imemAddress+loadAddress+uint32(offset))
+
+		if instruction.Mnemonic == "break" || instruction.IsUnconditional() && !instruction.Is(disasm.FlagCall) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// calls in overlays can go to the microcode loaded under them
+func buildCallGraph(name string, input *MicrocodeInput, loadAddress uint32, outer *callGraph) *callGraph {
+	var result = &callGraph{name, "", loadAddress, findRoutines(input, loadAddress), nil}
+
+	var resolve = func(address uint32) *routine {
+		var target = result.routineAt(address)
+
+		if target == nil && outer != nil {
+			target = outer.routineAt(address)
+		}
+
+		return target
+	}
+
+	for index, instruction := range disasm.DecodeText(input.Text, imemAddress+loadAddress) {
+		var address = loadAddress + uint32(index*4)
+		var caller = result.routineAt(address)
+
+		if caller == nil {
+			continue
+		}
+
+		if instruction.Is(disasm.FlagCall) && instruction.Is(disasm.FlagIndirect) {
+			result.Calls = append(result.Calls, callSite{caller.Name, "", address, callKindIndirect})
+		} else if instruction.HasTarget() {
+			var callee = resolve(instruction.Target - imemAddress)
+
+			if callee == nil {
+				continue
+			}
+
+			if instruction.Is(disasm.FlagCall) {
+				result.Calls = append(result.Calls, callSite{caller.Name, callee.Name, address, callKindCall})
+			} else if callee.Name != caller.Name {
+				// a jump or branch to another routine returns to whoever called this one
+				result.Calls = append(result.Calls, callSite{caller.Name, callee.Name, address, callKindTail})
+			}
+		}
+	}
+
+	return result
+}
+
+// marks routines that can't be reached from the start of the code, a
+// fall through or an address stored in DMEM, such as a command table
+func markDeadRoutines(graph *callGraph, text []byte, data []byte) {
+	var live = make(map[string]bool)
+	var pending []string = nil
+
+	var visit = func(name string) {
+		if !live[name] {
+			live[name] = true
+			pending = append(pending, name)
+		}
+	}
+
+	for index := range graph.Routines {
+		var routine = &graph.Routines[index]
+
+		if routine.Address == graph.LoadAddress {
+			visit(routine.Name)
+		}
+
+		for offset := 0; offset+2 <= len(data); offset += 2 {
+			var value = uint32(binary.BigEndian.Uint16(data[offset:]))
+
+			if value == routine.Address || value == routine.Address|imemFlag {
+				visit(routine.Name)
+				break
+			}
+		}
+	}
+
+	for len(pending) > 0 {
+		var name = pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for _, call := range graph.Calls {
+			if call.Caller == name && call.Callee != "" {
+				visit(call.Callee)
+			}
+		}
+
+		var routine = graph.routineNamed(name)
+
+		if routine != nil && fallsThrough(text, graph.LoadAddress, routine) {
+			var next = graph.routineAt(routine.Address + routine.Size)
+
+			if next != nil {
+				visit(next.Name)
+			}
+		}
+	}
+
+	for index := range graph.Routines {
+		graph.Routines[index].Dead = !live[graph.Routines[index].Name]
+	}
+}
+
+func buildCallGraphs(microcode *Microcode) []*callGraph {
+	var input = microcode.Input
+	var main = buildCallGraph(microcode.Name, input, microcode.LoadAddress, nil)
+	markDeadRoutines(main, input.Text, input.Data)
+
+	var result = []*callGraph{main}
+
+	for _, overlay := range input.Overlays {
+		var graph = buildCallGraph(overlay.Name, overlay.Input, overlay.LoadAddress, main)
+		graph.OverlayOf = microcode.Name
+		markDeadRoutines(graph, overlay.Input.Text, nil)
+		result = append(result, graph)
+	}
+
+	return result
+}
+
+// a subprogram for each routine with its calls as children
+// so gdb can find the caller of tail calls and entry values,
+// the gnu call sites are used since every profile is dwarf 4 or older
+func buildSubprograms(graph *callGraph, section string) []*dwarf.AbbrevTreeNode {
+	var result []*dwarf.AbbrevTreeNode = nil
+	var byName = make(map[string]*dwarf.AbbrevTreeNode)
+
+	for _, routine := range graph.Routines {
+		var offset = int64(routine.Address - graph.LoadAddress)
+		var node = &dwarf.AbbrevTreeNode{
+			Tag: dwarf.DW_TAG_subprogram,
+			Attributes: []dwarf.AbbrevAttr{
+				dwarf.CreateStringAttr(dwarf.DW_AT_name, routine.Name, false),
+				dwarf.CreateSectionAddrAttr(dwarf.DW_AT_low_pc, section, offset),
+				dwarf.CreateSectionAddrAttr(dwarf.DW_AT_high_pc, section, offset+int64(routine.Size)),
+				dwarf.CreateFlagAttr(dwarf.DW_AT_external, true),
+				dwarf.CreateFlagAttr(dwarf.DW_AT_GNU_all_call_sites, true),
+			},
+			Children: nil,
+		}
+
+		byName[routine.Name] = node
+		result = append(result, node)
+	}
+
+	for _, call := range graph.Calls {
+		var caller = byName[call.Caller]
+		var callee, hasCallee = byName[call.Callee]
+		var offset = int64(call.Address - graph.LoadAddress)
+		var attributes []dwarf.AbbrevAttr = nil
+
+		if call.Kind == callKindTail {
+			// tail calls never return so they are identified by the jump
+			attributes = append(attributes,
+				dwarf.CreateSectionAddrAttr(dwarf.DW_AT_low_pc, section, offset),
+				dwarf.CreateFlagAttr(dwarf.DW_AT_GNU_tail_call, true),
+			)
+		} else {
+			// the return address is after the delay slot
+			attributes = append(attributes, dwarf.CreateSectionAddrAttr(dwarf.DW_AT_low_pc, section, offset+8))
+		}
+
+		// callees in the microcode under an overlay are in another unit
+		if hasCallee {
+			attributes = append(attributes, dwarf.CreateReferenceAttr(dwarf.DW_AT_abstract_origin, callee))
+		}
+
+		caller.Children = append(caller.Children, &dwarf.AbbrevTreeNode{
+			Tag:        dwarf.DW_TAG_GNU_call_site,
+			Attributes: attributes,
+			Children:   nil,
+		})
+	}
+
+	return result
+}
+
+func reportDeadRoutines(graphs []*callGraph) {
+	var warnings []diagnostic = nil
+
+	for _, graph := range graphs {
+		for _, routine := range graph.Routines {
+			if routine.Dead {
+				warnings = append(warnings, createDiagnostic(graph.Name, 0, "%s at 0x%03X is never called", routine.Name, routine.Address))
+			}
+		}
+	}
+
+	reportWarnings(warnings)
+}
+
+func escapeDot(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(value)
+}
+
+func quoteDot(value string) string {
+	return "\"" + escapeDot(value) + "\""
+}
+
+func writeCallGraphDot(output *bytes.Buffer, graphs []*callGraph) {
+	output.WriteString("digraph calls {\n    node [shape=box];\n")
+
+	for index, graph := range graphs {
+		fmt.Fprintf(output, "    subgraph cluster_%d {\n        label=%s;\n", index, quoteDot(graph.Name))
+
+		for _, routine := range graph.Routines {
+			var style = ""
+
+			if routine.Dead {
+				style = ", style=dashed"
+			}
+
+			fmt.Fprintf(output, "        %s [label=%s%s];\n", quoteDot(graph.Name+"."+routine.Name), fmt.Sprintf("\"%s\\n0x%03X\"", escapeDot(routine.Name), routine.Address), style)
+		}
+
+		output.WriteString("    }\n")
+	}
+
+	for _, graph := range graphs {
+		for _, call := range graph.Calls {
+			if call.Callee == "" {
+				continue
+			}
+
+			// the callee is either in the same graph or the microcode under an overlay
+			var calleeName = graph.Name
+
+			if graph.routineNamed(call.Callee) == nil {
+				calleeName = graph.OverlayOf
+			}
+
+			var style = ""
+
+			if call.Kind == callKindTail {
+				style = " [style=dashed]"
+			}
+
+			fmt.Fprintf(output, "    %s -> %s%s;\n", quoteDot(graph.Name+"."+call.Caller), quoteDot(calleeName+"."+call.Callee), style)
+		}
+	}
+
+	output.WriteString("}\n")
+}
+
+// writes DOT unless the filename ends in .json
+func writeCallGraph(filename string, microcodes []*Microcode) error {
+	var output bytes.Buffer
+	var graphs []*callGraph = nil
+
+	for _, microcode := range microcodes {
+		graphs = append(graphs, buildCallGraphs(microcode)...)
+	}
+
+	reportDeadRoutines(graphs)
+
+	if !strings.HasSuffix(filename, ".json") {
+		writeCallGraphDot(&output, graphs)
+	} else {
+		data, err := json.MarshalIndent(graphs, "", "    ")
+
+		if err != nil {
+			return err
+		}
+
+		output.Write(data)
+		output.WriteByte('\n')
+	}
+
+	return ioutil.WriteFile(filename, output.Bytes(), 0664)
+}
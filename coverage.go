@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const coverageCommand = "coverage"
+
+type coverageArgs struct {
+	symbolizer *symbolizerArgs
+	pattern    string
+	output     string
+	htmlDir    string
+	traces     []string
+}
+
+func parseCoverageArgs(args []string) (*coverageArgs, error) {
+	var result = coverageArgs{nil, defaultTracePattern, "", "", nil}
+
+	if len(args) == 0 {
+		return nil, errors.New(`rsp2dwarf coverage [-o output.info] [-H directory] [-p pattern] [-b base] [-j section] [-i assembler] input [trace...]
+	-o    write an lcov tracefile, defaults to standard out when -H isn't given
+	-H    write an html report for each source file to directory
+	-p    regular expression finding the PC in each line, either in a group named pc or the first group
+	      defaults to a hex number at the start of the line
+` + symbolizerUsage() + `
+	traces are read from standard in when none are given`)
+	}
+
+	symbolizer, traces, err := parseSymbolizerArgs(args, func(flag string, value string) (bool, error) {
+		if flag == "-o" {
+			result.output = value
+		} else if flag == "-H" {
+			result.htmlDir = value
+		} else if flag == "-p" {
+			result.pattern = value
+		} else {
+			return false, nil
+		}
+
+		return true, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.symbolizer = symbolizer
+	result.traces = traces
+	return &result, nil
+}
+
+type routineCoverage struct {
+	name  string
+	line  int
+	count uint64
+}
+
+type fileCoverage struct {
+	filename string
+	// how many times each line with code ran
+	lines    map[int]uint64
+	routines []routineCoverage
+}
+
+func (file *fileCoverage) sortedLines() []int {
+	var result []int = nil
+
+	for line := range file.lines {
+		result = append(result, line)
+	}
+
+	sort.Ints(result)
+	return result
+}
+
+func (file *fileCoverage) linesHit() int {
+	var result = 0
+
+	for _, count := range file.lines {
+		if count > 0 {
+			result++
+		}
+	}
+
+	return result
+}
+
+func (file *fileCoverage) routinesHit() int {
+	var result = 0
+
+	for _, routine := range file.routines {
+		if routine.count > 0 {
+			result++
+		}
+	}
+
+	return result
+}
+
+// counts how many times each instruction ran, the counts take
+// the same memory no matter how long the trace is
+func countTracePCs(input io.Reader, parser traceParser, lookup *symbolizer, base uint32, counts []uint64) error {
+	var scanner = bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		pc, _, _, ok := parser.ParsePC(scanner.Text())
+
+		if !ok {
+			continue
+		}
+
+		offset, ok := lookup.offsetOf(pc, base)
+
+		if ok {
+			counts[offset/4]++
+		}
+	}
+
+	return scanner.Err()
+}
+
+// a line counts as many times as its most run instruction and a
+// routine as many times as its first instruction ran
+func buildFileCoverage(lookup *symbolizer, counts []uint64) []*fileCoverage {
+	var files = make(map[string]*fileCoverage)
+	var result []*fileCoverage = nil
+
+	var fileNamed = func(filename string) *fileCoverage {
+		var file = files[filename]
+
+		if file == nil {
+			file = &fileCoverage{filename, make(map[int]uint64), nil}
+			files[filename] = file
+			result = append(result, file)
+		}
+
+		return file
+	}
+
+	for index, row := range lookup.lines {
+		var end = int(lookup.size)
+
+		if index+1 < len(lookup.lines) {
+			end = lookup.lines[index+1].Address()
+		}
+
+		var file = fileNamed(row.Filename())
+		var count = file.lines[row.Line()]
+
+		for offset := row.Address() &^ 3; offset < end && offset/4 < len(counts); offset += 4 {
+			if counts[offset/4] > count {
+				count = counts[offset/4]
+			}
+		}
+
+		file.lines[row.Line()] = count
+	}
+
+	for _, symbol := range lookup.symbols {
+		if symbol.Kind == SymbolLocalLabel || symbol.Value >= lookup.size {
+			continue
+		}
+
+		var frame = lookup.frames(symbol.Value)[0]
+
+		if frame.line == 0 {
+			continue
+		}
+
+		var file = fileNamed(frame.filename)
+		file.routines = append(file.routines, routineCoverage{symbol.Name, frame.line, counts[symbol.Value/4]})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].filename < result[j].filename
+	})
+
+	return result
+}
+
+func writeLcov(output *bytes.Buffer, files []*fileCoverage) {
+	for _, file := range files {
+		fmt.Fprintf(output, "TN:\nSF:%s\n", file.filename)
+
+		for _, routine := range file.routines {
+			fmt.Fprintf(output, "FN:%d,%s\n", routine.line, routine.name)
+		}
+
+		for _, routine := range file.routines {
+			fmt.Fprintf(output, "FNDA:%d,%s\n", routine.count, routine.name)
+		}
+
+		fmt.Fprintf(output, "FNF:%d\nFNH:%d\n", len(file.routines), file.routinesHit())
+
+		for _, line := range file.sortedLines() {
+			fmt.Fprintf(output, "DA:%d,%d\n", line, file.lines[line])
+		}
+
+		fmt.Fprintf(output, "LF:%d\nLH:%d\nend_of_record\n", len(file.lines), file.linesHit())
+	}
+}
+
+const coverageStyle = `<style>
+body { font-family: monospace; }
+table { border-collapse: collapse; }
+td, th { padding: 0 8px; text-align: left; }
+.hit { background: #C0F0C0; }
+.missed { background: #F0C0C0; }
+.count { text-align: right; color: #606060; }
+</style>
+`
+
+func percentText(hit int, total int) string {
+	return fmt.Sprintf("%d/%d %.1f%%", hit, total, percentOf(hit, total))
+}
+
+// each source file gets a page named after its path
+func coveragePageName(filename string) string {
+	var result = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+
+		return '_'
+	}, filename)
+
+	return result + ".html"
+}
+
+func writeCoverageIndex(output *bytes.Buffer, files []*fileCoverage, title string) {
+	fmt.Fprintf(output, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n%s</head>\n<body>\n", html.EscapeString(title), coverageStyle)
+	fmt.Fprintf(output, "<h1>%s</h1>\n<table>\n<tr><th>file</th><th>lines</th><th>routines</th></tr>\n", html.EscapeString(title))
+
+	for _, file := range files {
+		var class = "hit"
+
+		if file.linesHit() < len(file.lines) {
+			class = "missed"
+		}
+
+		fmt.Fprintf(output, "<tr class=\"%s\"><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+			class,
+			html.EscapeString(coveragePageName(file.filename)),
+			html.EscapeString(file.filename),
+			percentText(file.linesHit(), len(file.lines)),
+			percentText(file.routinesHit(), len(file.routines)),
+		)
+	}
+
+	output.WriteString("</table>\n</body>\n</html>\n")
+}
+
+func writeCoverageFile(output *bytes.Buffer, file *fileCoverage, sources *sourceCache) {
+	fmt.Fprintf(output, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n%s</head>\n<body>\n", html.EscapeString(file.filename), coverageStyle)
+	fmt.Fprintf(output, "<h1>%s</h1>\n<p>lines %s, routines %s</p>\n", html.EscapeString(file.filename), percentText(file.linesHit(), len(file.lines)), percentText(file.routinesHit(), len(file.routines)))
+
+	if len(file.routines) > 0 {
+		output.WriteString("<table>\n<tr><th>routine</th><th>line</th><th>calls</th></tr>\n")
+
+		for _, routine := range file.routines {
+			var class = "hit"
+
+			if routine.count == 0 {
+				class = "missed"
+			}
+
+			fmt.Fprintf(output, "<tr class=\"%s\"><td>%s</td><td><a href=\"#L%d\">%d</a></td><td class=\"count\">%d</td></tr>\n", class, html.EscapeString(routine.name), routine.line, routine.line, routine.count)
+		}
+
+		output.WriteString("</table>\n")
+	}
+
+	output.WriteString("<table>\n")
+
+	var sortedLines = file.sortedLines()
+	var last = 0
+
+	if len(sortedLines) > 0 {
+		last = sortedLines[len(sortedLines)-1]
+	}
+
+	// show the whole file when it can be found, otherwise only lines with code
+	for line := 1; ; line++ {
+		text, ok := sources.line(file.filename, line)
+
+		if !ok && line > last {
+			break
+		}
+
+		count, hasCode := file.lines[line]
+
+		if !ok && !hasCode {
+			continue
+		}
+
+		var class = ""
+		var countText = ""
+
+		if hasCode {
+			countText = fmt.Sprintf("%d", count)
+			class = " class=\"hit\""
+
+			if count == 0 {
+				class = " class=\"missed\""
+			}
+		}
+
+		fmt.Fprintf(output, "<tr%s id=\"L%d\"><td class=\"count\">%d</td><td class=\"count\">%s</td><td><pre>%s</pre></td></tr>\n", class, line, line, countText, html.EscapeString(text))
+	}
+
+	output.WriteString("</table>\n</body>\n</html>\n")
+}
+
+func writeCoverageHtml(directory string, files []*fileCoverage, input string) error {
+	err := os.MkdirAll(directory, 0775)
+
+	if err != nil {
+		return err
+	}
+
+	var sources = &sourceCache{path.Dir(input), make(map[string][]string)}
+	var output bytes.Buffer
+
+	writeCoverageIndex(&output, files, path.Base(input))
+
+	err = ioutil.WriteFile(path.Join(directory, "index.html"), output.Bytes(), 0664)
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		output.Reset()
+		writeCoverageFile(&output, file, sources)
+
+		err = ioutil.WriteFile(path.Join(directory, coveragePageName(file.filename)), output.Bytes(), 0664)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runCoverage(args []string) error {
+	parsed, err := parseCoverageArgs(args)
+
+	if err != nil {
+		return err
+	}
+
+	parser, err := newRegexTraceParser(parsed.pattern)
+
+	if err != nil {
+		return err
+	}
+
+	lookup, err := parsed.symbolizer.load()
+
+	if err != nil {
+		return err
+	}
+
+	var counts = make([]uint64, (lookup.size+3)/4)
+
+	if len(parsed.traces) == 0 {
+		err = countTracePCs(os.Stdin, parser, lookup, parsed.symbolizer.base, counts)
+	}
+
+	for _, trace := range parsed.traces {
+		file, err := os.Open(trace)
+
+		if err != nil {
+			return err
+		}
+
+		err = countTracePCs(file, parser, lookup, parsed.symbolizer.base, counts)
+		file.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var files = buildFileCoverage(lookup, counts)
+
+	if parsed.htmlDir != "" {
+		err = writeCoverageHtml(parsed.htmlDir, files, parsed.symbolizer.input)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if parsed.output == "" && parsed.htmlDir != "" {
+		return nil
+	}
+
+	var output bytes.Buffer
+	writeLcov(&output, files)
+
+	if parsed.output == "" {
+		_, err = os.Stdout.Write(output.Bytes())
+		return err
+	}
+
+	return ioutil.WriteFile(parsed.output, output.Bytes(), 0664)
+}
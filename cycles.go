@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/disasm"
+)
+
+const cyclesCommand = "cycles"
+
+// rough costs for the RSP pipeline, this is only an estimate so
+// it doesn't know which way branches go or how long DMA takes
+
+// cycles after a load before the scalar register can be read
+const scalarLoadStall = 1
+
+// cycles after a vector load before the register can be read
+const vectorLoadStall = 3
+
+// fetch bubble after a branch or jump, branches are assumed taken
+const takenBranchPenalty = 1
+
+type instructionTiming struct {
+	stall  int
+	reason string
+	// issued in the same cycle as the instruction before it
+	paired bool
+}
+
+// offsets are from the start of the text
+type basicBlock struct {
+	start  int
+	end    int
+	cycles int
+	stalls int
+}
+
+type routineCycles struct {
+	routine routine
+	blocks  []basicBlock
+	cycles  int
+}
+
+type cycleEstimate struct {
+	timings  []instructionTiming
+	routines []routineCycles
+}
+
+// a scalar and a vector unit op can issue together
+// as long as the second doesn't need the first
+func canDualIssue(first *disasm.Instruction, second *disasm.Instruction) bool {
+	if first.IsVectorUnit() == second.IsVectorUnit() {
+		return false
+	}
+
+	var _, writes = first.Registers()
+	var reads, _ = second.Registers()
+
+	for _, written := range writes {
+		for _, read := range reads {
+			if written == read {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// each block starts with an empty pipeline, the cycles
+// include the branch penalty if it ends in a branch
+func simulateBlock(instructions []disasm.Instruction, timings []instructionTiming) (int, int) {
+	var cycle = 0
+	var stalls = 0
+	var lastIssue = 0
+	var readyAt = make(map[disasm.Register]int)
+	var loadedBy = make(map[disasm.Register]string)
+	var penalty = 0
+
+	for index := range instructions {
+		var instruction = &instructions[index]
+		var reads, writes = instruction.Registers()
+		var timing instructionTiming
+		var issue int
+
+		if instruction.HasDelaySlot() {
+			penalty = takenBranchPenalty
+		}
+
+		for _, register := range reads {
+			if readyAt[register]-cycle > timing.stall {
+				timing.stall = readyAt[register] - cycle
+				timing.reason = fmt.Sprintf("%s loaded by %s", register, loadedBy[register])
+			}
+		}
+
+		var previousReady = true
+
+		for _, register := range reads {
+			if readyAt[register] > lastIssue {
+				previousReady = false
+			}
+		}
+
+		if index > 0 && !timings[index-1].paired && previousReady && canDualIssue(&instructions[index-1], instruction) {
+			timing = instructionTiming{0, "", true}
+			issue = lastIssue
+		} else {
+			issue = cycle + timing.stall
+			cycle = issue + 1
+		}
+
+		for _, register := range writes {
+			readyAt[register] = issue + 1
+
+			if instruction.Is(disasm.FlagLoad) {
+				if register.IsVector() {
+					readyAt[register] += vectorLoadStall
+				} else {
+					readyAt[register] += scalarLoadStall
+				}
+
+				loadedBy[register] = instruction.Mnemonic
+			}
+		}
+
+		lastIssue = issue
+		stalls += timing.stall
+		timings[index] = timing
+	}
+
+	return cycle + penalty, stalls
+}
+
+// blocks start at entry points, branch targets and after delay slots
+func findBlockStarts(instructions []disasm.Instruction, routines []routine, loadAddress uint32) map[int]bool {
+	var result = map[int]bool{0: true}
+
+	for _, routine := range routines {
+		result[int(routine.Address-loadAddress)] = true
+	}
+
+	for index, instruction := range instructions {
+		if instruction.HasTarget() {
+			var target = int(instruction.Target) - int(imemAddress+loadAddress)
+
+			if target >= 0 && target < len(instructions)*4 {
+				result[target] = true
+			}
+		}
+
+		if instruction.HasDelaySlot() {
+			result[index*4+8] = true
+		}
+	}
+
+	return result
+}
+
+// name is used for the routine when there are no instruction symbols
+func estimateCycles(name string, input *MicrocodeInput, loadAddress uint32) *cycleEstimate {
+	var instructions = disasm.DecodeText(input.Text, imemAddress+loadAddress)
+	var count = len(instructions)
+	var result = &cycleEstimate{make([]instructionTiming, count), nil}
+
+	var routines = findRoutines(input, loadAddress)
+
+	if len(routines) == 0 && count > 0 {
+		routines = []routine{{name, loadAddress, uint32(count * 4), false}}
+	}
+
+	var starts = findBlockStarts(instructions, routines, loadAddress)
+	var blocks []basicBlock = nil
+
+	for start := 0; start < count; {
+		var end = start + 1
+
+		for end < count && !starts[end*4] {
+			end++
+		}
+
+		cycles, stalls := simulateBlock(instructions[start:end], result.timings[start:end])
+		blocks = append(blocks, basicBlock{start * 4, end * 4, cycles, stalls})
+		start = end
+	}
+
+	for _, routine := range routines {
+		var entry = routineCycles{routine, nil, 0}
+		var start = int(routine.Address - loadAddress)
+
+		for _, block := range blocks {
+			if block.start >= start && block.start < start+int(routine.Size) {
+				entry.blocks = append(entry.blocks, block)
+				entry.cycles += block.cycles
+			}
+		}
+
+		result.routines = append(result.routines, entry)
+	}
+
+	return result
+}
+
+func writeCycleReport(output *bytes.Buffer, filename string, input *MicrocodeInput, estimate *cycleEstimate) {
+	var locator = newTextLocator(filename, input.Lines)
+
+	for index, entry := range estimate.routines {
+		if index > 0 {
+			output.WriteString("\n")
+		}
+
+		fmt.Fprintf(output, "%s at 0x%03X: %d cycles\n", entry.routine.Name, entry.routine.Address, entry.cycles)
+
+		for _, block := range entry.blocks {
+			fmt.Fprintf(output, "  0x%03X-0x%03X %5d cycles %3d stalls\n", block.start, block.end-4, block.cycles, block.stalls)
+
+			for offset := block.start; offset < block.end; offset += 4 {
+				var timing = estimate.timings[offset/4]
+
+				if timing.stall > 0 {
+					fmt.Fprintf(output, "    %s\n", locator.diagnostic(offset, "%d cycle stall, %s", timing.stall, timing.reason).Error())
+				}
+			}
+		}
+	}
+}
+
+func runCycles(args []string) error {
+	var input = ""
+	var inputFormat = autoFrontend
+
+	if len(args) == 0 {
+		return errors.New(`rsp2dwarf cycles [-i assembler] input
+	-i    assembler that produced the input, ` + autoFrontend + ` or one of ` + strings.Join(frontendNames(), ", "))
+	}
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-i" {
+			if i+1 >= len(args) {
+				return errors.New("-i flag requires a parameter")
+			}
+
+			inputFormat = args[i+1]
+			i++
+		} else if args[i][0] == '-' {
+			return errors.New("Unknown flag " + args[i])
+		} else if input != "" {
+			return errors.New("Only one input file is allowed")
+		} else {
+			input = args[i]
+		}
+	}
+
+	if input == "" {
+		return errors.New("An input file is required")
+	}
+
+	frontend, err := findFrontend(inputFormat, input, DebugAll)
+
+	if err != nil {
+		return err
+	}
+
+	microcode, err := frontend.Load(input, DebugAll, 0)
+
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	writeCycleReport(&output, input, microcode, estimateCycles(path.Base(input), microcode, 0))
+
+	_, err = os.Stdout.Write(output.Bytes())
+	return err
+}
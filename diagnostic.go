@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type diagnostic struct {
+	filename string
+	line     int
+	message  string
+}
+
+func createDiagnostic(filename string, line int, format string, args ...interface{}) diagnostic {
+	return diagnostic{filename, line, fmt.Sprintf(format, args...)}
+}
+
+func (diag diagnostic) Error() string {
+	if diag.line == 0 {
+		return fmt.Sprintf("%s: %s", diag.filename, diag.message)
+	}
+
+	return fmt.Sprintf("%s:%d: %s", diag.filename, diag.line, diag.message)
+}
+
+func reportWarnings(warnings []diagnostic) {
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "warning: "+warning.Error())
+	}
+}
@@ -0,0 +1,463 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var registerNames = [32]string{
+	"$zero", "$at", "$v0", "$v1", "$a0", "$a1", "$a2", "$a3",
+	"$t0", "$t1", "$t2", "$t3", "$t4", "$t5", "$t6", "$t7",
+	"$s0", "$s1", "$s2", "$s3", "$s4", "$s5", "$s6", "$s7",
+	"$t8", "$t9", "$k0", "$k1", "$gp", "$sp", "$fp", "$ra",
+}
+
+// the RSP maps the SP and DP registers into COP0
+var cop0Names = [16]string{
+	"SP_MEM_ADDR", "SP_DRAM_ADDR", "SP_RD_LEN", "SP_WR_LEN",
+	"SP_STATUS", "SP_DMA_FULL", "SP_DMA_BUSY", "SP_SEMAPHORE",
+	"DPC_START", "DPC_END", "DPC_CURRENT", "DPC_STATUS",
+	"DPC_CLOCK", "DPC_BUFBUSY", "DPC_PIPEBUSY", "DPC_TMEM",
+}
+
+var cop2ControlNames = [3]string{"$vco", "$vcc", "$vce"}
+
+var specialNames = map[uint32]string{
+	0x00: "sll",
+	0x02: "srl",
+	0x03: "sra",
+	0x04: "sllv",
+	0x06: "srlv",
+	0x07: "srav",
+	0x08: "jr",
+	0x09: "jalr",
+	0x0D: "break",
+	0x20: "add",
+	0x21: "addu",
+	0x22: "sub",
+	0x23: "subu",
+	0x24: "and",
+	0x25: "or",
+	0x26: "xor",
+	0x27: "nor",
+	0x2A: "slt",
+	0x2B: "sltu",
+}
+
+var regimmNames = map[uint32]string{
+	0x00: "bltz",
+	0x01: "bgez",
+	0x10: "bltzal",
+	0x11: "bgezal",
+}
+
+var immediateNames = map[uint32]string{
+	0x08: "addi",
+	0x09: "addiu",
+	0x0A: "slti",
+	0x0B: "sltiu",
+	0x0C: "andi",
+	0x0D: "ori",
+	0x0E: "xori",
+	0x0F: "lui",
+}
+
+var branchNames = map[uint32]string{
+	0x04: "beq",
+	0x05: "bne",
+	0x06: "blez",
+	0x07: "bgtz",
+}
+
+var loadStoreNames = map[uint32]string{
+	0x20: "lb",
+	0x21: "lh",
+	0x23: "lw",
+	0x24: "lbu",
+	0x25: "lhu",
+	0x28: "sb",
+	0x29: "sh",
+	0x2B: "sw",
+}
+
+var vectorNames = map[uint32]string{
+	0x00: "vmulf",
+	0x01: "vmulu",
+	0x02: "vrndp",
+	0x03: "vmulq",
+	0x04: "vmudl",
+	0x05: "vmudm",
+	0x06: "vmudn",
+	0x07: "vmudh",
+	0x08: "vmacf",
+	0x09: "vmacu",
+	0x0A: "vrndn",
+	0x0B: "vmacq",
+	0x0C: "vmadl",
+	0x0D: "vmadm",
+	0x0E: "vmadn",
+	0x0F: "vmadh",
+	0x10: "vadd",
+	0x11: "vsub",
+	0x13: "vabs",
+	0x14: "vaddc",
+	0x15: "vsubc",
+	0x1D: "vsar",
+	0x20: "vlt",
+	0x21: "veq",
+	0x22: "vne",
+	0x23: "vge",
+	0x24: "vcl",
+	0x25: "vch",
+	0x26: "vcr",
+	0x27: "vmrg",
+	0x28: "vand",
+	0x29: "vnand",
+	0x2A: "vor",
+	0x2B: "vnor",
+	0x2C: "vxor",
+	0x2D: "vnxor",
+	0x30: "vrcp",
+	0x31: "vrcpl",
+	0x32: "vrcph",
+	0x33: "vmov",
+	0x34: "vrsq",
+	0x35: "vrsql",
+	0x36: "vrsqh",
+	0x37: "vnop",
+}
+
+// the names after the l or s of lwc2 and swc2 ops
+var vectorLoadStoreNames = map[uint32]string{
+	0x0: "bv",
+	0x1: "sv",
+	0x2: "lv",
+	0x3: "dv",
+	0x4: "qv",
+	0x5: "rv",
+	0x6: "pv",
+	0x7: "uv",
+	0x8: "hv",
+	0x9: "fv",
+	0xA: "wv",
+	0xB: "tv",
+}
+
+// the offset is in units of the size being moved
+var vectorLoadStoreScale = map[uint32]int32{
+	0x0: 1,
+	0x1: 2,
+	0x2: 4,
+	0x3: 8,
+	0x4: 16,
+	0x5: 16,
+	0x6: 8,
+	0x7: 8,
+	0x8: 16,
+	0x9: 16,
+	0xA: 16,
+	0xB: 16,
+}
+
+func register(index uint32) string {
+	return registerNames[index&0x1F]
+}
+
+func vectorRegister(index uint32) string {
+	return fmt.Sprintf("$v%d", index&0x1F)
+}
+
+// e selects which lanes of vt are broadcast to the operation
+func vectorElement(element uint32) string {
+	if element < 2 {
+		return ""
+	} else if element < 4 {
+		return fmt.Sprintf("[%dq]", element-2)
+	} else if element < 8 {
+		return fmt.Sprintf("[%dh]", element-4)
+	}
+
+	return fmt.Sprintf("[%d]", element-8)
+}
+
+func signedHex(value int32) string {
+	if value < 0 {
+		return fmt.Sprintf("-0x%X", -int64(value))
+	}
+
+	return fmt.Sprintf("0x%X", value)
+}
+
+func signExtend(value uint32, bits uint) int32 {
+	var shift = 32 - bits
+	return int32(value<<shift) >> shift
+}
+
+// the program counter is 12 bits so targets wrap around inside IMEM
+func imemTarget(address uint32, offset uint32) uint32 {
+	return address&^0xFFF | offset&0xFFC
+}
+
+func invalidInstruction(result Instruction) Instruction {
+	result.Mnemonic = ".word"
+	result.Operands = []string{fmt.Sprintf("0x%08X", result.Word)}
+	result.Flags = FlagInvalid
+	return result
+}
+
+func decodeSpecial(result Instruction) Instruction {
+	var word = result.Word
+	var rs = (word >> 21) & 0x1F
+	var rt = (word >> 16) & 0x1F
+	var rd = (word >> 11) & 0x1F
+	var sa = (word >> 6) & 0x1F
+	var funct = word & 0x3F
+
+	name, ok := specialNames[funct]
+
+	if !ok {
+		return invalidInstruction(result)
+	}
+
+	result.Mnemonic = name
+
+	switch funct {
+	case 0x00, 0x02, 0x03:
+		if word == 0 {
+			result.Mnemonic = "nop"
+		} else {
+			result.Operands = []string{register(rd), register(rt), fmt.Sprintf("%d", sa)}
+		}
+	case 0x04, 0x06, 0x07:
+		result.Operands = []string{register(rd), register(rt), register(rs)}
+	case 0x08:
+		result.Operands = []string{register(rs)}
+		result.Flags = FlagJump | FlagIndirect
+	case 0x09:
+		if rd == 31 {
+			result.Operands = []string{register(rs)}
+		} else {
+			result.Operands = []string{register(rd), register(rs)}
+		}
+
+		result.Flags = FlagJump | FlagIndirect | FlagCall
+	case 0x0D:
+	default:
+		result.Operands = []string{register(rd), register(rs), register(rt)}
+	}
+
+	return result
+}
+
+func decodeCop0(result Instruction) Instruction {
+	var rs = (result.Word >> 21) & 0x1F
+	var rt = (result.Word >> 16) & 0x1F
+	var rd = (result.Word >> 11) & 0x1F
+
+	if rs == 0x00 {
+		result.Mnemonic = "mfc0"
+	} else if rs == 0x04 {
+		result.Mnemonic = "mtc0"
+	} else {
+		return invalidInstruction(result)
+	}
+
+	result.Operands = []string{register(rt), cop0Names[rd&0xF]}
+
+	return result
+}
+
+func decodeCop2(result Instruction) Instruction {
+	var word = result.Word
+	var rs = (word >> 21) & 0x1F
+	var rt = (word >> 16) & 0x1F
+	var rd = (word >> 11) & 0x1F
+
+	result.Flags = FlagVector
+
+	if rs&0x10 == 0 {
+		switch rs {
+		case 0x00, 0x04:
+			result.Mnemonic = "mfc2"
+
+			if rs == 0x04 {
+				result.Mnemonic = "mtc2"
+			}
+
+			result.Operands = []string{register(rt), vectorRegister(rd) + fmt.Sprintf("[%d]", (word>>7)&0xF)}
+		case 0x02, 0x06:
+			result.Mnemonic = "cfc2"
+
+			if rs == 0x06 {
+				result.Mnemonic = "ctc2"
+			}
+
+			var control = fmt.Sprintf("$%d", rd)
+
+			if int(rd) < len(cop2ControlNames) {
+				control = cop2ControlNames[rd]
+			}
+
+			result.Operands = []string{register(rt), control}
+		default:
+			return invalidInstruction(result)
+		}
+
+		return result
+	}
+
+	var element = rs & 0xF
+	var vt = rt
+	var vs = rd
+	var vd = (word >> 6) & 0x1F
+	var funct = word & 0x3F
+
+	name, ok := vectorNames[funct]
+
+	if !ok {
+		return invalidInstruction(result)
+	}
+
+	result.Mnemonic = name
+
+	if funct == 0x37 {
+		// vnop has no operands
+	} else if funct >= 0x30 {
+		// single lane ops use vs to pick the lane of vd being written
+		result.Operands = []string{
+			vectorRegister(vd) + fmt.Sprintf("[%d]", vs&0x7),
+			vectorRegister(vt) + vectorElement(element),
+		}
+	} else {
+		result.Operands = []string{
+			vectorRegister(vd),
+			vectorRegister(vs),
+			vectorRegister(vt) + vectorElement(element),
+		}
+	}
+
+	return result
+}
+
+func decodeVectorLoadStore(result Instruction, isStore bool) Instruction {
+	var word = result.Word
+	var base = (word >> 21) & 0x1F
+	var vt = (word >> 16) & 0x1F
+	var opcode = (word >> 11) & 0x1F
+	var element = (word >> 7) & 0xF
+
+	name, ok := vectorLoadStoreNames[opcode]
+
+	if !ok {
+		return invalidInstruction(result)
+	}
+
+	var offset = signExtend(word&0x7F, 7) * vectorLoadStoreScale[opcode]
+
+	if isStore {
+		result.Mnemonic = "s" + name
+		result.Flags = FlagVector | FlagStore
+	} else {
+		result.Mnemonic = "l" + name
+		result.Flags = FlagVector | FlagLoad
+	}
+
+	result.Operands = []string{
+		vectorRegister(vt) + fmt.Sprintf("[%d]", element),
+		signedHex(offset) + "(" + register(base) + ")",
+	}
+
+	return result
+}
+
+// decodes a single RSP instruction loaded at address
+func Decode(word uint32, address uint32) Instruction {
+	var result = Instruction{address, word, "", nil, 0, 0}
+
+	var opcode = word >> 26
+	var rs = (word >> 21) & 0x1F
+	var rt = (word >> 16) & 0x1F
+	var immediate = word & 0xFFFF
+	var branchTarget = imemTarget(address, address+4+uint32(signExtend(immediate, 16)<<2))
+
+	switch opcode {
+	case 0x00:
+		return decodeSpecial(result)
+	case 0x01:
+		name, ok := regimmNames[rt]
+
+		if !ok {
+			return invalidInstruction(result)
+		}
+
+		result.Mnemonic = name
+		result.Flags = FlagBranch
+		result.Target = branchTarget
+		result.Operands = []string{register(rs), fmt.Sprintf("0x%08X", result.Target)}
+
+		if rt&0x10 != 0 {
+			result.Flags |= FlagCall
+		}
+	case 0x02, 0x03:
+		result.Mnemonic = "j"
+		result.Flags = FlagJump
+		result.Target = imemTarget(address, (word&0x3FFFFFF)<<2)
+		result.Operands = []string{fmt.Sprintf("0x%08X", result.Target)}
+
+		if opcode == 0x03 {
+			result.Mnemonic = "jal"
+			result.Flags |= FlagCall
+		}
+	case 0x04, 0x05:
+		result.Mnemonic = branchNames[opcode]
+		result.Flags = FlagBranch
+		result.Target = branchTarget
+		result.Operands = []string{register(rs), register(rt), fmt.Sprintf("0x%08X", result.Target)}
+	case 0x06, 0x07:
+		result.Mnemonic = branchNames[opcode]
+		result.Flags = FlagBranch
+		result.Target = branchTarget
+		result.Operands = []string{register(rs), fmt.Sprintf("0x%08X", result.Target)}
+	case 0x08, 0x09, 0x0A, 0x0B:
+		result.Mnemonic = immediateNames[opcode]
+		result.Operands = []string{register(rt), register(rs), signedHex(signExtend(immediate, 16))}
+	case 0x0C, 0x0D, 0x0E:
+		result.Mnemonic = immediateNames[opcode]
+		result.Operands = []string{register(rt), register(rs), fmt.Sprintf("0x%X", immediate)}
+	case 0x0F:
+		result.Mnemonic = immediateNames[opcode]
+		result.Operands = []string{register(rt), fmt.Sprintf("0x%X", immediate)}
+	case 0x10:
+		return decodeCop0(result)
+	case 0x12:
+		return decodeCop2(result)
+	case 0x20, 0x21, 0x23, 0x24, 0x25, 0x28, 0x29, 0x2B:
+		result.Mnemonic = loadStoreNames[opcode]
+		result.Operands = []string{register(rt), signedHex(signExtend(immediate, 16)) + "(" + register(rs) + ")"}
+
+		if opcode >= 0x28 {
+			result.Flags = FlagStore
+		} else {
+			result.Flags = FlagLoad
+		}
+	case 0x32:
+		return decodeVectorLoadStore(result, false)
+	case 0x3A:
+		return decodeVectorLoadStore(result, true)
+	default:
+		return invalidInstruction(result)
+	}
+
+	return result
+}
+
+// decodes each big endian word of text with the first loaded at address
+func DecodeText(text []byte, address uint32) []Instruction {
+	var result = make([]Instruction, len(text)/4)
+
+	for index := range result {
+		result[index] = Decode(binary.BigEndian.Uint32(text[index*4:]), address+uint32(index*4))
+	}
+
+	return result
+}
@@ -0,0 +1,90 @@
+package disasm
+
+import "testing"
+
+var decodeTests = []struct {
+	address uint32
+	word    uint32
+	text    string
+	flags   InstructionFlags
+}{
+	// cop2 with each form of the element
+	{0x04001000, 0x4A00002C, "vxor    $v0, $v0, $v0", FlagVector},
+	{0x04001000, 0x4A031050, "vadd    $v1, $v2, $v3", FlagVector},
+	{0x04001000, 0x4AE62907, "vmudh   $v4, $v5, $v6[3h]", FlagVector},
+	{0x04001000, 0x4A7F0008, "vmacf   $v0, $v0, $v31[1q]", FlagVector},
+	{0x04001000, 0x4BEA4A00, "vmulf   $v8, $v9, $v10[7]", FlagVector},
+	{0x04001000, 0x4B621070, "vrcp    $v1[2], $v2[3]", FlagVector},
+	{0x04001000, 0x4A000037, "vnop", FlagVector},
+	{0x04001000, 0x48881A00, "mtc2    $t0, $v3[4]", FlagVector},
+	{0x04001000, 0x48440800, "cfc2    $a0, $vcc", FlagVector},
+	{0x04001000, 0x4A031052, ".word   0x4A031052", FlagInvalid},
+	// lwc2 and swc2 offsets are scaled by the size moved
+	{0x04001000, 0xC8012001, "lqv     $v1[0], 0x10($zero)", FlagVector | FlagLoad},
+	{0x04001000, 0xCA021C7F, "ldv     $v2[8], -0x8($s0)", FlagVector | FlagLoad},
+	{0x04001000, 0xE8830902, "ssv     $v3[2], 0x4($a0)", FlagVector | FlagStore},
+	{0x04001000, 0xCBA507C0, "lbv     $v5[15], -0x40($sp)", FlagVector | FlagLoad},
+	{0x04001000, 0xE807587C, "stv     $v7[0], -0x40($zero)", FlagVector | FlagStore},
+	{0x04001000, 0xC8016000, ".word   0xC8016000", FlagInvalid},
+	// regimm branches wrap around inside IMEM
+	{0x04001000, 0x05000003, "bltz    $t0, 0x04001010", FlagBranch},
+	{0x04001004, 0x0491FFFE, "bgezal  $a0, 0x04001000", FlagBranch | FlagCall},
+	{0x04001FFC, 0x05210001, "bgez    $t1, 0x04001004", FlagBranch},
+	{0x04001000, 0x0500FFFE, "bltz    $t0, 0x04001FFC", FlagBranch},
+	{0x04001000, 0x05100000, "bltzal  $t0, 0x04001004", FlagBranch | FlagCall},
+	{0x04001000, 0x05020000, ".word   0x05020000", FlagInvalid},
+	// jumps only keep the low 12 bits of the target
+	{0x04001008, 0x0C000006, "jal     0x04001018", FlagJump | FlagCall},
+	{0x04001008, 0x0C000406, "jal     0x04001018", FlagJump | FlagCall},
+	{0x04001080, 0x08000400, "j       0x04001000", FlagJump},
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range decodeTests {
+		var instruction = Decode(test.word, test.address)
+
+		if instruction.String() != test.text {
+			t.Errorf("0x%08X at 0x%08X decoded as %q, expected %q", test.word, test.address, instruction.String(), test.text)
+		}
+
+		if instruction.Flags != test.flags {
+			t.Errorf("0x%08X at 0x%08X has flags 0x%X, expected 0x%X", test.word, test.address, instruction.Flags, test.flags)
+		}
+	}
+}
+
+func TestIsUnconditional(t *testing.T) {
+	var tests = []struct {
+		word          uint32
+		unconditional bool
+	}{
+		{0x10000003, true},  // b
+		{0x04110003, true},  // bal
+		{0x18000003, true},  // blez $zero
+		{0x11090003, false}, // beq $t0, $t1
+		{0x05010003, false}, // bgez $t0
+		{0x03E00008, true},  // jr $ra
+		{0x0C000006, true},  // jal
+		{0x4A00002C, false}, // vxor
+	}
+
+	for _, test := range tests {
+		var instruction = Decode(test.word, 0x04001000)
+
+		if instruction.IsUnconditional() != test.unconditional {
+			t.Errorf("%s is unconditional %t, expected %t", instruction.String(), instruction.IsUnconditional(), test.unconditional)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	var instructions = DecodeText([]byte{0x0C, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00, 0xFF}, 0x04001080)
+
+	if len(instructions) != 2 {
+		t.Fatalf("decoded %d instructions, expected 2", len(instructions))
+	}
+
+	if instructions[0].Target != 0x04001018 || instructions[1].Address != 0x04001084 || instructions[1].Mnemonic != "nop" {
+		t.Errorf("unexpected instructions %v", instructions)
+	}
+}
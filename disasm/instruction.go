@@ -0,0 +1,93 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type InstructionFlags uint32
+
+const (
+	// conditional branches, the target is relative to the delay slot
+	FlagBranch InstructionFlags = 1 << iota
+	FlagJump
+	// saves the return address in a register
+	FlagCall
+	// jumps to the address in a register so there is no known target
+	FlagIndirect
+	FlagVector
+	FlagLoad
+	FlagStore
+	FlagInvalid
+)
+
+type Instruction struct {
+	Address  uint32
+	Word     uint32
+	Mnemonic string
+	Operands []string
+	Flags    InstructionFlags
+	// where a branch or jump goes, only valid if HasTarget is true
+	Target uint32
+}
+
+func (instruction *Instruction) Is(flags InstructionFlags) bool {
+	return instruction.Flags&flags != 0
+}
+
+func (instruction *Instruction) HasTarget() bool {
+	return instruction.Is(FlagBranch|FlagJump) && !instruction.Is(FlagIndirect)
+}
+
+// jumps and the branches that always go, such as b and bal
+func (instruction *Instruction) IsUnconditional() bool {
+	var opcode = instruction.Word >> 26
+	var rs = (instruction.Word >> 21) & 0x1F
+	var rt = (instruction.Word >> 16) & 0x1F
+
+	switch {
+	case instruction.Is(FlagJump):
+		return true
+	case !instruction.Is(FlagBranch):
+		return false
+	case opcode == 0x04:
+		// beq of a register with itself
+		return rs == rt
+	case opcode == 0x06:
+		// blez $zero
+		return rs == 0
+	case opcode == 0x01:
+		// bgez and bgezal $zero
+		return rs == 0 && (rt == 0x01 || rt == 0x11)
+	}
+
+	return false
+}
+
+func (instruction *Instruction) HasDelaySlot() bool {
+	return instruction.Is(FlagBranch | FlagJump)
+}
+
+// symbolize is given a branch target and returns the label to show
+// in place of the address, it can be nil to always show addresses
+func (instruction *Instruction) Format(symbolize func(address uint32) (string, bool)) string {
+	var operands = instruction.Operands
+
+	if instruction.HasTarget() && symbolize != nil {
+		label, ok := symbolize(instruction.Target)
+
+		if ok {
+			operands = append(append([]string(nil), operands[:len(operands)-1]...), label)
+		}
+	}
+
+	if len(operands) == 0 {
+		return instruction.Mnemonic
+	}
+
+	return fmt.Sprintf("%-8s%s", instruction.Mnemonic, strings.Join(operands, ", "))
+}
+
+func (instruction *Instruction) String() string {
+	return instruction.Format(nil)
+}
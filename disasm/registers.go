@@ -0,0 +1,142 @@
+package disasm
+
+// scalar registers are 0 to 31 followed by the 32 vector registers
+type Register uint8
+
+const VectorRegisters Register = 32
+
+func vector(index uint32) Register {
+	return VectorRegisters + Register(index&0x1F)
+}
+
+func (register Register) IsVector() bool {
+	return register >= VectorRegisters
+}
+
+func (register Register) String() string {
+	if register.IsVector() {
+		return vectorRegister(uint32(register - VectorRegisters))
+	}
+
+	return registerNames[register&0x1F]
+}
+
+// $zero is left out since it never changes
+func scalars(indices ...uint32) []Register {
+	var result []Register = nil
+
+	for _, index := range indices {
+		if index&0x1F != 0 {
+			result = append(result, Register(index&0x1F))
+		}
+	}
+
+	return result
+}
+
+// true for ops run by the vector unit, everything else including
+// vector loads, stores and moves is run by the scalar unit
+func (instruction *Instruction) IsVectorUnit() bool {
+	return instruction.Word>>26 == 0x12 && instruction.Word&(1<<25) != 0 && !instruction.Is(FlagInvalid)
+}
+
+// the registers an instruction reads and the ones it writes
+func (instruction *Instruction) Registers() (reads []Register, writes []Register) {
+	if instruction.Is(FlagInvalid) {
+		return nil, nil
+	}
+
+	var word = instruction.Word
+	var opcode = word >> 26
+	var rs = (word >> 21) & 0x1F
+	var rt = (word >> 16) & 0x1F
+	var rd = (word >> 11) & 0x1F
+
+	switch opcode {
+	case 0x00:
+		switch word & 0x3F {
+		case 0x00, 0x02, 0x03:
+			return scalars(rt), scalars(rd)
+		case 0x08:
+			return scalars(rs), nil
+		case 0x09:
+			return scalars(rs), scalars(rd)
+		case 0x0D:
+			return nil, nil
+		}
+
+		return scalars(rs, rt), scalars(rd)
+	case 0x01:
+		if rt&0x10 != 0 {
+			return scalars(rs), scalars(31)
+		}
+
+		return scalars(rs), nil
+	case 0x02:
+		return nil, nil
+	case 0x03:
+		return nil, scalars(31)
+	case 0x04, 0x05:
+		return scalars(rs, rt), nil
+	case 0x06, 0x07:
+		return scalars(rs), nil
+	case 0x0F:
+		return nil, scalars(rt)
+	case 0x10:
+		if rs == 0x04 {
+			return scalars(rt), nil
+		}
+
+		return nil, scalars(rt)
+	case 0x12:
+		if rs&0x10 == 0 {
+			switch rs {
+			case 0x00:
+				return []Register{vector(rd)}, scalars(rt)
+			case 0x04:
+				return scalars(rt), []Register{vector(rd)}
+			case 0x02:
+				return nil, scalars(rt)
+			}
+
+			return scalars(rt), nil
+		}
+
+		var vd = (word >> 6) & 0x1F
+
+		switch funct := word & 0x3F; {
+		case funct == 0x37:
+			return nil, nil
+		case funct >= 0x30:
+			return []Register{vector(rt)}, []Register{vector(vd)}
+		}
+
+		return []Register{vector(rd), vector(rt)}, []Register{vector(vd)}
+	case 0x32:
+		return scalars(rs), []Register{vector(rt)}
+	case 0x3A:
+		return append(scalars(rs), vector(rt)), nil
+	}
+
+	if instruction.Is(FlagStore) {
+		return scalars(rs, rt), nil
+	}
+
+	return scalars(rs), scalars(rt)
+}
+
+// the base register and offset in bytes of a load or store
+func (instruction *Instruction) MemoryOperand() (Register, int32, bool) {
+	if !instruction.Is(FlagLoad | FlagStore) {
+		return 0, 0, false
+	}
+
+	var word = instruction.Word
+	var base = Register((word >> 21) & 0x1F)
+
+	if word>>26 == 0x32 || word>>26 == 0x3A {
+		return base, signExtend(word&0x7F, 7) * vectorLoadStoreScale[(word>>11)&0x1F], true
+	}
+
+	return base, signExtend(word&0xFFFF, 16), true
+}
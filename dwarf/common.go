@@ -42,20 +42,46 @@ func writeSLEB128(writer io.Writer, value int64) {
 	}
 }
 
-func readLEB128(reader io.Reader) int64 {
-	var result int64 = 0
+func readULEB128(reader io.ByteReader) (uint64, error) {
+	var result uint64 = 0
+	var shift uint = 0
 
-	var hasMore = true
-	var tmp = make([]byte, 1)
+	for {
+		value, err := reader.ReadByte()
 
-	for hasMore {
-		reader.Read(tmp)
+		if err != nil {
+			return 0, err
+		}
 
-		result = result << 7
-		result = result | int64(tmp[0]&0x7f)
+		result |= uint64(value&0x7f) << shift
+		shift += 7
 
-		hasMore = tmp[0]&0x80 != 0
+		if value&0x80 == 0 {
+			return result, nil
+		}
 	}
+}
+
+func readSLEB128(reader io.ByteReader) (int64, error) {
+	var result int64 = 0
+	var shift uint = 0
+
+	for {
+		value, err := reader.ReadByte()
+
+		if err != nil {
+			return 0, err
+		}
 
-	return result
+		result |= int64(value&0x7f) << shift
+		shift += 7
+
+		if value&0x80 == 0 {
+			if shift < 64 && value&0x40 != 0 {
+				result |= -1 << shift
+			}
+
+			return result, nil
+		}
+	}
 }
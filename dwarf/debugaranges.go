@@ -7,35 +7,43 @@ import (
 	"github.com/lambertjamesd/rsp2dwarf/elf"
 )
 
-func GenerateAranges(textSegmentLength int, byteOrder binary.ByteOrder) ([]byte, *elf.RelocationBuilder) {
+type AddressRange struct {
+	Section string
+	Length  int
+	// offset of the compilation unit in .debug_info
+	InfoOffset uint32
+}
+
+func GenerateAranges(ranges []AddressRange, byteOrder binary.ByteOrder) ([]byte, *elf.RelocationBuilder) {
 	var result bytes.Buffer
 	var relBuilder = elf.NewRelocationBuilder()
 
-	var size uint32 = 0x1C
-	binary.Write(&result, byteOrder, &size)
-	var version uint16 = 2
-	binary.Write(&result, byteOrder, &version)
-	var offset uint32 = 0
-	binary.Write(&result, byteOrder, &offset)
-	result.WriteByte(4) // size of instruction
-	result.WriteByte(0) // segment descriptor size
-
-	// padding
-	result.WriteByte(0)
-	result.WriteByte(0)
-	result.WriteByte(0)
-	result.WriteByte(0)
-
-	// single range entry
-	relBuilder.AddEntry(uint32(result.Len()), ".text", elf.R_MIPS_32)
-	binary.Write(&result, byteOrder, &offset)
-	size = uint32(textSegmentLength)
-	relBuilder.AddEntry(uint32(result.Len()), ".text", elf.R_MIPS_32)
-	binary.Write(&result, byteOrder, &size)
-
-	// null terminator
-	binary.Write(&result, byteOrder, &offset)
-	binary.Write(&result, byteOrder, &offset)
+	for _, addressRange := range ranges {
+		var size uint32 = 0x1C
+		binary.Write(&result, byteOrder, &size)
+		var version uint16 = 2
+		binary.Write(&result, byteOrder, &version)
+		binary.Write(&result, byteOrder, &addressRange.InfoOffset)
+		result.WriteByte(4) // size of instruction
+		result.WriteByte(0) // segment descriptor size
+
+		// padding
+		result.WriteByte(0)
+		result.WriteByte(0)
+		result.WriteByte(0)
+		result.WriteByte(0)
+
+		// single range entry, only the start moves with the section
+		var offset uint32 = 0
+		relBuilder.AddEntry(uint32(result.Len()), addressRange.Section, elf.R_MIPS_32)
+		binary.Write(&result, byteOrder, &offset)
+		size = uint32(addressRange.Length)
+		binary.Write(&result, byteOrder, &size)
+
+		// null terminator
+		binary.Write(&result, byteOrder, &offset)
+		binary.Write(&result, byteOrder, &offset)
+	}
 
 	return result.Bytes(), relBuilder
 }
@@ -60,6 +60,9 @@ const (
 	DW_TAG_volatile_type          DW_TAG = 0x35
 	DW_TAG_lo_user                DW_TAG = 0x4080
 	DW_TAG_hi_user                DW_TAG = 0xffff
+
+	// gnu extensions
+	DW_TAG_GNU_call_site DW_TAG = 0x4109
 )
 
 type DW_AT uint32
@@ -126,6 +129,15 @@ const (
 	DW_AT_vtable_elem_location DW_AT = 0x4d
 	DW_AT_lo_user              DW_AT = 0x2000
 	DW_AT_hi_user              DW_AT = 0x3fff
+
+	// dwarf 3
+	DW_AT_call_column DW_AT = 0x57
+	DW_AT_call_file   DW_AT = 0x58
+	DW_AT_call_line   DW_AT = 0x59
+
+	// gnu extensions
+	DW_AT_GNU_tail_call      DW_AT = 0x2115
+	DW_AT_GNU_all_call_sites DW_AT = 0x2117
 )
 
 type DW_FORM uint32
@@ -152,6 +164,63 @@ const (
 	DW_FORM_ref8      DW_FORM = 0x14
 	DW_FORM_ref_udata DW_FORM = 0x15
 	DW_FORM_indirect  DW_FORM = 0x16
+	// dwarf 4
+	DW_FORM_sec_offset   DW_FORM = 0x17
+	DW_FORM_exprloc      DW_FORM = 0x18
+	DW_FORM_flag_present DW_FORM = 0x19
+	DW_FORM_ref_sig8     DW_FORM = 0x20
+	// dwarf 5
+	DW_FORM_strx           DW_FORM = 0x1a
+	DW_FORM_addrx          DW_FORM = 0x1b
+	DW_FORM_ref_sup4       DW_FORM = 0x1c
+	DW_FORM_strp_sup       DW_FORM = 0x1d
+	DW_FORM_data16         DW_FORM = 0x1e
+	DW_FORM_line_strp      DW_FORM = 0x1f
+	DW_FORM_implicit_const DW_FORM = 0x21
+	DW_FORM_loclistx       DW_FORM = 0x22
+	DW_FORM_rnglistx       DW_FORM = 0x23
+	DW_FORM_ref_sup8       DW_FORM = 0x24
+	DW_FORM_strx1          DW_FORM = 0x25
+	DW_FORM_strx2          DW_FORM = 0x26
+	DW_FORM_strx3          DW_FORM = 0x27
+	DW_FORM_strx4          DW_FORM = 0x28
+	DW_FORM_addrx1         DW_FORM = 0x29
+	DW_FORM_addrx2         DW_FORM = 0x2a
+	DW_FORM_addrx3         DW_FORM = 0x2b
+	DW_FORM_addrx4         DW_FORM = 0x2c
+)
+
+type DW_OP uint8
+
+const (
+	DW_OP_addr DW_OP = 0x03
+)
+
+type DW_RLE uint8
+
+const (
+	DW_RLE_end_of_list   DW_RLE = 0x00
+	DW_RLE_base_addressx DW_RLE = 0x01
+	DW_RLE_startx_endx   DW_RLE = 0x02
+	DW_RLE_startx_length DW_RLE = 0x03
+	DW_RLE_offset_pair   DW_RLE = 0x04
+	DW_RLE_base_address  DW_RLE = 0x05
+	DW_RLE_start_end     DW_RLE = 0x06
+	DW_RLE_start_length  DW_RLE = 0x07
+)
+
+type DW_LLE uint8
+
+const (
+	DW_LLE_end_of_list      DW_LLE = 0x00
+	DW_LLE_base_addressx    DW_LLE = 0x01
+	DW_LLE_startx_endx      DW_LLE = 0x02
+	DW_LLE_startx_length    DW_LLE = 0x03
+	DW_LLE_offset_pair      DW_LLE = 0x04
+	DW_LLE_default_location DW_LLE = 0x05
+	DW_LLE_base_address     DW_LLE = 0x06
+	DW_LLE_start_end        DW_LLE = 0x07
+	DW_LLE_start_length     DW_LLE = 0x08
 )
 
 type AttributeValue interface {
@@ -228,6 +297,43 @@ func (value Indirect) WriteOut(writer io.Writer, byteOrder binary.ByteOrder, deb
 	return value.Value.WriteOut(writer, byteOrder, debugStr)
 }
 
+// an address that gets relocated against the start of a section
+type AddressValue struct {
+	Section string
+	Value   int64
+}
+
+func (value AddressValue) WriteOut(writer io.Writer, byteOrder binary.ByteOrder, debugStr []byte) []byte {
+	writeOutNumber(writer, byteOrder, value.Value, 4)
+	return debugStr
+}
+
+// a DW_OP_addr expression for an address in a section
+type LocationValue struct {
+	Section string
+	Value   int64
+}
+
+// where the address is in the written out expression
+const locationAddressOffset = 2
+
+func (value LocationValue) WriteOut(writer io.Writer, byteOrder binary.ByteOrder, debugStr []byte) []byte {
+	writer.Write([]byte{5, byte(DW_OP_addr)})
+	writeOutNumber(writer, byteOrder, value.Value, 4)
+	return debugStr
+}
+
+// a reference to another entry in the same compilation unit
+type ReferenceValue struct {
+	Node *AbbrevTreeNode
+}
+
+func (value ReferenceValue) WriteOut(writer io.Writer, byteOrder binary.ByteOrder, debugStr []byte) []byte {
+	// filled in once the offset of the node is known
+	writeOutNumber(writer, byteOrder, 0, 4)
+	return debugStr
+}
+
 type AbbrevAttr struct {
 	Type  DW_AT
 	Form  DW_FORM
@@ -235,10 +341,29 @@ type AbbrevAttr struct {
 }
 
 func CreateAddrAttr(at DW_AT, data int64) AbbrevAttr {
+	return CreateSectionAddrAttr(at, ".text", data)
+}
+
+func CreateSectionAddrAttr(at DW_AT, section string, data int64) AbbrevAttr {
 	return AbbrevAttr{
 		at,
 		DW_FORM_addr,
-		NumberValue{data, 4},
+		AddressValue{section, data},
+	}
+}
+
+// locations are blocks before dwarf 4
+func CreateSectionLocationAttr(at DW_AT, section string, data int64, version uint16) AbbrevAttr {
+	var form = DW_FORM_exprloc
+
+	if version < 4 {
+		form = DW_FORM_block1
+	}
+
+	return AbbrevAttr{
+		at,
+		form,
+		LocationValue{section, data},
 	}
 }
 
@@ -265,6 +390,41 @@ func CreateConstantAttr(at DW_AT, data int64, size uint32) AbbrevAttr {
 	}
 }
 
+// offsets into other debug sections are data4 before dwarf 4
+func CreateSectionOffsetAttr(at DW_AT, data int64, version uint16) AbbrevAttr {
+	if version < 4 {
+		return CreateConstantAttr(at, data, 4)
+	}
+
+	return AbbrevAttr{
+		at,
+		DW_FORM_sec_offset,
+		NumberValue{data, 4},
+	}
+}
+
+func CreateReferenceAttr(at DW_AT, node *AbbrevTreeNode) AbbrevAttr {
+	return AbbrevAttr{
+		at,
+		DW_FORM_ref4,
+		ReferenceValue{node},
+	}
+}
+
+func CreateFlagAttr(at DW_AT, value bool) AbbrevAttr {
+	var asNumber int64 = 0
+
+	if value {
+		asNumber = 1
+	}
+
+	return AbbrevAttr{
+		at,
+		DW_FORM_flag,
+		NumberValue{asNumber, 1},
+	}
+}
+
 func CreateStringAttr(at DW_AT, data string, inline bool) AbbrevAttr {
 	var dwType DW_FORM
 
@@ -292,6 +452,8 @@ type InfoData struct {
 	RelInfo  *elf.RelocationBuilder
 	Abbrev   []byte
 	DebugStr []byte
+	// offset in Info of the unit for each top level node
+	UnitOffsets []uint32
 }
 
 func generateAbbrv(input []*AbbrevTreeNode, result *bytes.Buffer, currId int, idMapping map[*AbbrevTreeNode]int) int {
@@ -303,7 +465,6 @@ func generateAbbrv(input []*AbbrevTreeNode, result *bytes.Buffer, currId int, id
 
 		if len(node.Children) > 0 {
 			result.WriteByte(1)
-			currId = generateAbbrv(node.Children, result, currId, idMapping)
 		} else {
 			result.WriteByte(0)
 		}
@@ -317,39 +478,64 @@ func generateAbbrv(input []*AbbrevTreeNode, result *bytes.Buffer, currId int, id
 		result.WriteByte(0)
 		result.WriteByte(0)
 
-		currId++
+		currId = generateAbbrv(node.Children, result, currId+1, idMapping)
 	}
 
-	// null termiante node list
-	result.WriteByte(0)
-
 	return currId
 }
 
-func generateInfo(input []*AbbrevTreeNode, result *bytes.Buffer, rel *elf.RelocationBuilder, strBytes []byte, byteOrder binary.ByteOrder, idMapping map[*AbbrevTreeNode]int) []byte {
+type referenceFixup struct {
+	position int
+	node     *AbbrevTreeNode
+}
+
+func generateInfo(input []*AbbrevTreeNode, result *bytes.Buffer, rel *elf.RelocationBuilder, strBytes []byte, byteOrder binary.ByteOrder, idMapping map[*AbbrevTreeNode]int, offsets map[*AbbrevTreeNode]int, fixups *[]referenceFixup) []byte {
 	for _, node := range input {
 		id, ok := idMapping[node]
 
 		if ok {
+			offsets[node] = result.Len()
 			writeULEB128(result, uint64(id))
 
 			for _, attr := range node.Attributes {
-				if attr.Form == DW_FORM_addr {
-					// kinda hacky but works for now
-					rel.AddEntry(uint32(result.Len()), ".text", elf.R_MIPS_32)
+				address, isAddress := attr.Value.(AddressValue)
+
+				if isAddress {
+					rel.AddEntry(uint32(result.Len()), address.Section, elf.R_MIPS_32)
+				}
+
+				location, isLocation := attr.Value.(LocationValue)
+
+				if isLocation {
+					rel.AddEntry(uint32(result.Len()+locationAddressOffset), location.Section, elf.R_MIPS_32)
+				}
+
+				reference, isReference := attr.Value.(ReferenceValue)
+
+				if isReference {
+					*fixups = append(*fixups, referenceFixup{result.Len(), reference.Node})
 				}
 
 				strBytes = attr.Value.WriteOut(result, byteOrder, strBytes)
 			}
 
-			strBytes = generateInfo(node.Children, result, rel, strBytes, byteOrder, idMapping)
+			if len(node.Children) > 0 {
+				strBytes = generateInfo(node.Children, result, rel, strBytes, byteOrder, idMapping, offsets, fixups)
+				// null entry ends the list of children
+				result.WriteByte(0)
+			}
 		}
 	}
 
 	return strBytes
 }
 
-func GenerateInfoAndAbbrev(input []*AbbrevTreeNode, byteOrder binary.ByteOrder) InfoData {
+// the length, version, abbrev offset and address size of a unit,
+// dwarf 5 adds a unit type but no profile generates version 5
+const unitHeaderSize = 11
+
+// each top level node is written as its own compilation unit
+func GenerateInfoAndAbbrev(input []*AbbrevTreeNode, version uint16, byteOrder binary.ByteOrder) InfoData {
 	var result InfoData
 	var relBuilder = elf.NewRelocationBuilder()
 
@@ -357,29 +543,51 @@ func GenerateInfoAndAbbrev(input []*AbbrevTreeNode, byteOrder binary.ByteOrder)
 	var abbrevBytes bytes.Buffer
 
 	generateAbbrv(input, &abbrevBytes, 1, idMapping)
+	// null terminate the abbreviation table
+	abbrevBytes.WriteByte(0)
 
 	result.Abbrev = abbrevBytes.Bytes()
 
-	var infoBytes bytes.Buffer
+	var finalInfo bytes.Buffer
+	var strBytes = make([]byte, 1)
 
-	result.DebugStr = generateInfo(input, &infoBytes, relBuilder, make([]byte, 1), byteOrder, idMapping)
+	for _, unit := range input {
+		var infoBytes bytes.Buffer
+		var unitRel = elf.NewRelocationBuilder()
+		var offsets = make(map[*AbbrevTreeNode]int)
+		var fixups []referenceFixup = nil
 
-	var finalInfo bytes.Buffer
-	var totalLength = uint32(infoBytes.Len()) + 7
-	binary.Write(&finalInfo, byteOrder, &totalLength)
+		strBytes = generateInfo([]*AbbrevTreeNode{unit}, &infoBytes, unitRel, strBytes, byteOrder, idMapping, offsets, &fixups)
+
+		var data = infoBytes.Bytes()
+
+		for _, fixup := range fixups {
+			offset, ok := offsets[fixup.node]
+
+			if ok {
+				// references are relative to the start of the unit header
+				byteOrder.PutUint32(data[fixup.position:], uint32(offset+unitHeaderSize))
+			}
+		}
+
+		result.UnitOffsets = append(result.UnitOffsets, uint32(finalInfo.Len()))
 
-	var version = uint16(2)
-	binary.Write(&finalInfo, byteOrder, &version)
+		var totalLength = uint32(infoBytes.Len()) + 7
+		binary.Write(&finalInfo, byteOrder, &totalLength)
 
-	var offset = uint32(0)
-	binary.Write(&finalInfo, byteOrder, &offset)
-	finalInfo.WriteByte(4)
+		binary.Write(&finalInfo, byteOrder, &version)
 
-	relBuilder.AddOffset(uint32(finalInfo.Len()))
-	finalInfo.Write(infoBytes.Bytes())
+		var offset = uint32(0)
+		binary.Write(&finalInfo, byteOrder, &offset)
+		finalInfo.WriteByte(4)
+
+		relBuilder.Append(unitRel, uint32(finalInfo.Len()))
+		finalInfo.Write(infoBytes.Bytes())
+	}
 
 	result.Info = finalInfo.Bytes()
 	result.RelInfo = relBuilder
+	result.DebugStr = strBytes
 
 	return result
 }
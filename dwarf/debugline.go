@@ -10,13 +10,25 @@ import (
 
 const lineBase = 0
 const lineRange = 16
-const opcodeBase = 10
 const minInstructionLen = 4
 
 var opcodeLengths = []byte{
 	0, 1, 1, 1, 1, 0, 0, 0, 1,
 }
 
+// dwarf 3 added prologue_end, epilogue_begin and set_isa
+var opcodeLengthsV3 = []byte{
+	0, 1, 1, 1, 1, 0, 0, 0, 1, 0, 0, 1,
+}
+
+func standardOpcodeLengths(version uint16) []byte {
+	if version >= 3 {
+		return opcodeLengthsV3
+	}
+
+	return opcodeLengths
+}
+
 const (
 	DW_LNS_copy             = 1
 	DW_LNS_advance_pc       = 2
@@ -27,12 +39,25 @@ const (
 	DW_LNS_set_basic_block  = 7
 	DW_LNS_const_add_pc     = 8
 	DW_LNS_fixed_advance_pc = 9
+	// dwarf 3
+	DW_LNS_set_prologue_end   = 10
+	DW_LNS_set_epilogue_begin = 11
+	DW_LNS_set_isa            = 12
+)
+
+const (
+	DW_LNE_end_sequence      = 1
+	DW_LNE_set_address       = 2
+	DW_LNE_define_file       = 3
+	DW_LNE_set_discriminator = 4
 )
 
 const (
-	DW_LNE_end_sequence = 1
-	DW_LNE_set_address  = 2
-	DW_LNE_define_file  = 3
+	DW_LNCT_path            = 1
+	DW_LNCT_directory_index = 2
+	DW_LNCT_timestamp       = 3
+	DW_LNCT_size            = 4
+	DW_LNCT_MD5             = 5
 )
 
 type InstructionEntry struct {
@@ -42,6 +67,7 @@ type InstructionEntry struct {
 	col         int
 	isStatement bool
 	isBlock     bool
+	prologueEnd bool
 }
 
 func CreateInstructionEntry(
@@ -59,6 +85,7 @@ func CreateInstructionEntry(
 		col,
 		isStatement,
 		isBlock,
+		false,
 	}
 }
 
@@ -66,6 +93,42 @@ func (entry *InstructionEntry) Filename() string {
 	return entry.filename
 }
 
+func (entry *InstructionEntry) Address() int {
+	return entry.address
+}
+
+func (entry *InstructionEntry) Line() int {
+	return entry.line
+}
+
+func (entry *InstructionEntry) Column() int {
+	return entry.col
+}
+
+func (entry *InstructionEntry) IsStatement() bool {
+	return entry.isStatement
+}
+
+func (entry *InstructionEntry) IsBlock() bool {
+	return entry.isBlock
+}
+
+func (entry *InstructionEntry) IsPrologueEnd() bool {
+	return entry.prologueEnd
+}
+
+func (entry InstructionEntry) WithFlags(isStatement bool, isBlock bool, prologueEnd bool) InstructionEntry {
+	entry.isStatement = isStatement
+	entry.isBlock = isBlock
+	entry.prologueEnd = prologueEnd
+	return entry
+}
+
+func (entry InstructionEntry) WithAddress(address int) InstructionEntry {
+	entry.address = address
+	return entry
+}
+
 type instructionEntryByAddress []InstructionEntry
 
 func (arr instructionEntryByAddress) Len() int {
@@ -105,7 +168,10 @@ func sortAndFilter(instructions []InstructionEntry) []InstructionEntry {
 		for readIndex < len(sorted) &&
 			sorted[readIndex].line == sorted[written].line &&
 			sorted[readIndex].col == sorted[written].col &&
-			sorted[readIndex].filename == sorted[written].filename {
+			sorted[readIndex].filename == sorted[written].filename &&
+			sorted[readIndex].isStatement == sorted[written].isStatement &&
+			!sorted[readIndex].isBlock &&
+			!sorted[readIndex].prologueEnd {
 			// skip duplicate lines
 			readIndex++
 		}
@@ -114,7 +180,7 @@ func sortAndFilter(instructions []InstructionEntry) []InstructionEntry {
 	return sorted[0:writeIndex]
 }
 
-func getSpecialOpcode(lineDelta int, instructionDelta int) int {
+func getSpecialOpcode(lineDelta int, instructionDelta int, opcodeBase int) int {
 	if lineDelta >= lineRange || lineDelta < lineBase {
 		return -1
 	}
@@ -131,14 +197,14 @@ func findFile(files []string, filename string) int {
 	return 0
 }
 
-func generateOpCodes(instructions []InstructionEntry, files []string, isStmt bool) []byte {
+func generateOpCodes(instructions []InstructionEntry, files []string, isStmt bool, version uint16) []byte {
 	var result bytes.Buffer
 
 	var address = 0
 	var file = 1
 	var line = 1
 	var col = 0
-	var basicBlock = false
+	var opcodeBase = len(standardOpcodeLengths(version)) + 1
 
 	result.WriteByte(0) // extended opcode
 	result.WriteByte(5) // size of extended operation
@@ -163,9 +229,13 @@ func generateOpCodes(instructions []InstructionEntry, files []string, isStmt boo
 			col = inst.col
 		}
 
-		if !basicBlock && inst.isBlock {
+		// basic_block and prologue_end are cleared after each row
+		if inst.isBlock {
 			result.WriteByte(DW_LNS_set_basic_block)
-			basicBlock = true
+		}
+
+		if inst.prologueEnd && version >= 3 {
+			result.WriteByte(DW_LNS_set_prologue_end)
 		}
 
 		if inst.isStatement != isStmt {
@@ -173,7 +243,7 @@ func generateOpCodes(instructions []InstructionEntry, files []string, isStmt boo
 			isStmt = !isStmt
 		}
 
-		var specialOp = getSpecialOpcode(inst.line-line, inst.address-address)
+		var specialOp = getSpecialOpcode(inst.line-line, inst.address-address, opcodeBase)
 
 		if specialOp >= opcodeBase && specialOp < 256 {
 			result.WriteByte(byte(specialOp))
@@ -203,7 +273,8 @@ func generateOpCodes(instructions []InstructionEntry, files []string, isStmt boo
 	return result.Bytes()
 }
 
-func GenerateDebugLines(instructions []InstructionEntry, byteOrder binary.ByteOrder) ([]byte, *elf.RelocationBuilder) {
+// addresses in instructions are relative to the start of section
+func GenerateDebugLines(instructions []InstructionEntry, section string, version uint16, byteOrder binary.ByteOrder) ([]byte, *elf.RelocationBuilder) {
 	var sorted = sortAndFilter(instructions)
 	var relBuilder = elf.NewRelocationBuilder()
 
@@ -217,21 +288,29 @@ func GenerateDebugLines(instructions []InstructionEntry, byteOrder binary.ByteOr
 		}
 	}
 
-	var generated = generateOpCodes(sorted, files, sorted[0].isStatement)
+	var lengths = standardOpcodeLengths(version)
+	var generated = generateOpCodes(sorted, files, sorted[0].isStatement, version)
 
 	var result bytes.Buffer
 
 	var prologueLength = uint32(
-		7 + len(opcodeLengths) +
+		7 + len(lengths) +
 			filesNameByteLength + len(files)*4,
 	)
 
+	if version >= 4 {
+		// maximum_operations_per_instruction
+		prologueLength++
+	}
+
 	var totalLength = uint32(len(generated)) + prologueLength + 6
 	binary.Write(&result, byteOrder, &totalLength)
-	var version = uint16(2)
 	binary.Write(&result, byteOrder, &version)
 	binary.Write(&result, byteOrder, &prologueLength)
 	result.WriteByte(minInstructionLen)
+	if version >= 4 {
+		result.WriteByte(1)
+	}
 	if sorted[0].isStatement {
 		result.WriteByte(1)
 	} else {
@@ -239,8 +318,8 @@ func GenerateDebugLines(instructions []InstructionEntry, byteOrder binary.ByteOr
 	}
 	result.WriteByte(lineBase)
 	result.WriteByte(lineRange)
-	result.WriteByte(opcodeBase)
-	result.Write(opcodeLengths)
+	result.WriteByte(byte(len(lengths) + 1))
+	result.Write(lengths)
 
 	result.WriteByte(0) // directories
 
@@ -254,7 +333,7 @@ func GenerateDebugLines(instructions []InstructionEntry, byteOrder binary.ByteOr
 
 	result.WriteByte(0) // end of files
 
-	relBuilder.AddEntry(uint32(result.Len())+3, ".text", elf.R_MIPS_32)
+	relBuilder.AddEntry(uint32(result.Len())+3, section, elf.R_MIPS_32)
 
 	result.Write(generated)
 
@@ -0,0 +1,100 @@
+package dwarf
+
+import (
+	"errors"
+	"fmt"
+)
+
+type formValue struct {
+	number   uint64
+	str      string
+	isString bool
+	// set for DW_FORM_addr
+	address *AddressField
+	// offset in .debug_info of the entry a reference form points to
+	reference   int
+	isReference bool
+}
+
+type unitInfo struct {
+	version      uint16
+	addressSize  int
+	debugStr     []byte
+	debugLineStr []byte
+	// offset of the unit header in .debug_info
+	offset int
+}
+
+func readForm(reader *sectionReader, form DW_FORM, unit *unitInfo, implicitConst int64) (formValue, error) {
+	var result formValue
+
+	switch form {
+	case DW_FORM_addr:
+		var field = reader.addressField(unit.addressSize)
+		result.number = field.Value
+		result.address = &field
+	case DW_FORM_block2:
+		reader.take(int(reader.u16()))
+	case DW_FORM_block4:
+		reader.take(int(reader.u32()))
+	case DW_FORM_block, DW_FORM_exprloc:
+		reader.take(int(reader.uleb()))
+	case DW_FORM_block1:
+		reader.take(int(reader.u8()))
+	case DW_FORM_data1, DW_FORM_ref1, DW_FORM_flag, DW_FORM_strx1, DW_FORM_addrx1:
+		result.number = uint64(reader.u8())
+	case DW_FORM_data2, DW_FORM_ref2, DW_FORM_strx2, DW_FORM_addrx2:
+		result.number = uint64(reader.u16())
+	case DW_FORM_strx3, DW_FORM_addrx3:
+		reader.take(3)
+	case DW_FORM_data4, DW_FORM_ref4, DW_FORM_sec_offset, DW_FORM_ref_sup4, DW_FORM_strp_sup, DW_FORM_strx4, DW_FORM_addrx4:
+		result.number = uint64(reader.u32())
+	case DW_FORM_data8, DW_FORM_ref8, DW_FORM_ref_sig8, DW_FORM_ref_sup8:
+		result.number = reader.u64()
+	case DW_FORM_data16:
+		reader.take(16)
+	case DW_FORM_string:
+		result.str = reader.cstring()
+		result.isString = true
+	case DW_FORM_strp:
+		result.str = stringAt(unit.debugStr, uint64(reader.u32()))
+		result.isString = true
+	case DW_FORM_line_strp:
+		result.str = stringAt(unit.debugLineStr, uint64(reader.u32()))
+		result.isString = true
+	case DW_FORM_sdata:
+		result.number = uint64(reader.sleb())
+	case DW_FORM_udata, DW_FORM_ref_udata, DW_FORM_strx, DW_FORM_addrx, DW_FORM_loclistx, DW_FORM_rnglistx:
+		result.number = reader.uleb()
+	case DW_FORM_ref_addr:
+		if unit.version <= 2 {
+			result.number = reader.sized(unit.addressSize)
+		} else {
+			result.number = uint64(reader.u32())
+		}
+	case DW_FORM_flag_present:
+		result.number = 1
+	case DW_FORM_implicit_const:
+		result.number = uint64(implicitConst)
+	case DW_FORM_indirect:
+		return readForm(reader, DW_FORM(reader.uleb()), unit, implicitConst)
+	default:
+		return result, fmt.Errorf("Unsupported dwarf form 0x%x", uint32(form))
+	}
+
+	if reader.err != nil {
+		return result, errors.New("Truncated dwarf attribute")
+	}
+
+	switch form {
+	case DW_FORM_ref1, DW_FORM_ref2, DW_FORM_ref4, DW_FORM_ref8, DW_FORM_ref_udata:
+		// relative to the start of the unit header
+		result.reference = unit.offset + int(result.number)
+		result.isReference = true
+	case DW_FORM_ref_addr:
+		result.reference = int(result.number)
+		result.isReference = true
+	}
+
+	return result, nil
+}
@@ -0,0 +1,187 @@
+package dwarf
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errTruncated = errors.New("Unexpected end of debug section")
+
+// reads values out of a debug section, once a read goes past the end
+// of the data every following read returns zero and err is set
+type sectionReader struct {
+	data      []byte
+	pos       int
+	byteOrder binary.ByteOrder
+	err       error
+}
+
+func newSectionReader(data []byte, byteOrder binary.ByteOrder) *sectionReader {
+	return &sectionReader{data, 0, byteOrder, nil}
+}
+
+func (reader *sectionReader) take(size int) []byte {
+	if reader.err != nil || size < 0 || reader.pos+size > len(reader.data) {
+		reader.err = errTruncated
+		return nil
+	}
+
+	var result = reader.data[reader.pos : reader.pos+size]
+	reader.pos += size
+	return result
+}
+
+func (reader *sectionReader) ReadByte() (byte, error) {
+	var value = reader.take(1)
+
+	if value == nil {
+		return 0, reader.err
+	}
+
+	return value[0], nil
+}
+
+func (reader *sectionReader) u8() uint8 {
+	value, _ := reader.ReadByte()
+	return value
+}
+
+func (reader *sectionReader) u16() uint16 {
+	var value = reader.take(2)
+
+	if value == nil {
+		return 0
+	}
+
+	return reader.byteOrder.Uint16(value)
+}
+
+func (reader *sectionReader) u32() uint32 {
+	var value = reader.take(4)
+
+	if value == nil {
+		return 0
+	}
+
+	return reader.byteOrder.Uint32(value)
+}
+
+func (reader *sectionReader) u64() uint64 {
+	var value = reader.take(8)
+
+	if value == nil {
+		return 0
+	}
+
+	return reader.byteOrder.Uint64(value)
+}
+
+func (reader *sectionReader) sized(size int) uint64 {
+	switch size {
+	case 1:
+		return uint64(reader.u8())
+	case 2:
+		return uint64(reader.u16())
+	case 4:
+		return uint64(reader.u32())
+	case 8:
+		return reader.u64()
+	}
+
+	reader.take(size)
+
+	return 0
+}
+
+func (reader *sectionReader) uleb() uint64 {
+	if reader.err != nil {
+		return 0
+	}
+
+	value, err := readULEB128(reader)
+
+	if err != nil {
+		reader.err = err
+	}
+
+	return value
+}
+
+func (reader *sectionReader) sleb() int64 {
+	if reader.err != nil {
+		return 0
+	}
+
+	value, err := readSLEB128(reader)
+
+	if err != nil {
+		reader.err = err
+	}
+
+	return value
+}
+
+func (reader *sectionReader) cstring() string {
+	var start = reader.pos
+
+	for reader.pos < len(reader.data) && reader.data[reader.pos] != 0 {
+		reader.pos++
+	}
+
+	if reader.pos >= len(reader.data) {
+		reader.err = errTruncated
+		return ""
+	}
+
+	reader.pos++
+
+	return string(reader.data[start : reader.pos-1])
+}
+
+// reads the initial length of a unit and returns the offset of the next unit
+func (reader *sectionReader) unitLength() (int, error) {
+	var length = reader.u32()
+
+	if reader.err != nil {
+		return 0, reader.err
+	}
+
+	if length >= 0xfffffff0 {
+		return 0, errors.New("64 bit dwarf is not supported")
+	}
+
+	var end = reader.pos + int(length)
+
+	if end > len(reader.data) {
+		return 0, errTruncated
+	}
+
+	return end, nil
+}
+
+func stringAt(data []byte, offset uint64) string {
+	if offset >= uint64(len(data)) {
+		return ""
+	}
+
+	var end = offset
+
+	for end < uint64(len(data)) && data[end] != 0 {
+		end++
+	}
+
+	return string(data[offset:end])
+}
+
+// the location of an address inside a debug section that
+// needs to be adjusted when the code it points to moves
+type AddressField struct {
+	Offset int
+	Size   int
+	Value  uint64
+}
+
+func (reader *sectionReader) addressField(size int) AddressField {
+	var offset = reader.pos
+	return AddressField{offset, size, reader.sized(size)}
+}
@@ -0,0 +1,493 @@
+package dwarf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type abbrevAttrSpec struct {
+	at            DW_AT
+	form          DW_FORM
+	implicitConst int64
+}
+
+type abbrevEntry struct {
+	tag         DW_TAG
+	hasChildren bool
+	attributes  []abbrevAttrSpec
+}
+
+func parseAbbrevTable(debugAbbrev []byte, offset uint32) (map[uint64]*abbrevEntry, error) {
+	var result = make(map[uint64]*abbrevEntry)
+
+	if int(offset) > len(debugAbbrev) {
+		return nil, errTruncated
+	}
+
+	var reader = newSectionReader(debugAbbrev, binary.BigEndian)
+	reader.pos = int(offset)
+
+	for reader.err == nil {
+		var code = reader.uleb()
+
+		if code == 0 {
+			break
+		}
+
+		var entry = &abbrevEntry{DW_TAG(reader.uleb()), reader.u8() != 0, nil}
+
+		for reader.err == nil {
+			var at = DW_AT(reader.uleb())
+			var form = DW_FORM(reader.uleb())
+
+			if at == 0 && form == 0 {
+				break
+			}
+
+			var spec = abbrevAttrSpec{at, form, 0}
+
+			if form == DW_FORM_implicit_const {
+				spec.implicitConst = reader.sleb()
+			}
+
+			entry.attributes = append(entry.attributes, spec)
+		}
+
+		result[code] = entry
+	}
+
+	return result, reader.err
+}
+
+type DebugEntry struct {
+	Tag        DW_TAG
+	attributes map[DW_AT]formValue
+	Children   []*DebugEntry
+	// offset of the entry in .debug_info
+	Offset int
+}
+
+func (entry *DebugEntry) Number(at DW_AT) (uint64, bool) {
+	value, ok := entry.attributes[at]
+
+	if !ok || value.isString {
+		return 0, false
+	}
+
+	return value.number, true
+}
+
+func (entry *DebugEntry) String(at DW_AT) string {
+	return entry.attributes[at].str
+}
+
+// the offset in .debug_info of the entry an attribute refers to
+func (entry *DebugEntry) Reference(at DW_AT) (int, bool) {
+	value, ok := entry.attributes[at]
+
+	if !ok || !value.isReference {
+		return 0, false
+	}
+
+	return value.reference, true
+}
+
+// where a DW_FORM_addr attribute is so its relocation can be found
+func (entry *DebugEntry) AddressField(at DW_AT) (AddressField, bool) {
+	value, ok := entry.attributes[at]
+
+	if !ok || value.address == nil {
+		return AddressField{}, false
+	}
+
+	return *value.address, true
+}
+
+// DW_AT_high_pc is an offset from DW_AT_low_pc unless it uses DW_FORM_addr
+func (entry *DebugEntry) PCRange() (uint64, uint64, bool) {
+	lowPC, hasLow := entry.attributes[DW_AT_low_pc]
+	highPC, hasHigh := entry.attributes[DW_AT_high_pc]
+
+	if !hasLow || !hasHigh {
+		return 0, 0, false
+	}
+
+	if highPC.address != nil {
+		return lowPC.number, highPC.number, true
+	}
+
+	return lowPC.number, lowPC.number + highPC.number, true
+}
+
+type DebugInfoSections struct {
+	Info    []byte
+	Abbrev  []byte
+	Str     []byte
+	LineStr []byte
+}
+
+// walks every unit in .debug_info returning the tree of entries in each
+// along with the location of each DW_FORM_addr attribute value
+func walkDebugInfo(sections DebugInfoSections, byteOrder binary.ByteOrder) ([]*DebugEntry, []AddressField, error) {
+	var reader = newSectionReader(sections.Info, byteOrder)
+	var units []*DebugEntry = nil
+	var addresses []AddressField = nil
+
+	for reader.pos < len(reader.data) {
+		var unitStart = reader.pos
+		unitEnd, err := reader.unitLength()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var unit = unitInfo{reader.u16(), 4, sections.Str, sections.LineStr, unitStart}
+		var abbrevOffset uint32
+
+		if unit.version >= 5 {
+			var unitType = reader.u8()
+			unit.addressSize = int(reader.u8())
+			abbrevOffset = reader.u32()
+
+			switch unitType {
+			case 2, 6: // type units
+				reader.take(12)
+			case 4, 5: // skeleton and split units
+				reader.take(8)
+			}
+		} else {
+			abbrevOffset = reader.u32()
+			unit.addressSize = int(reader.u8())
+		}
+
+		if reader.err != nil {
+			return nil, nil, reader.err
+		}
+
+		// abbrev tables are read with the same byte order
+		// as everything else since they only contain leb128
+		abbrevs, err := parseAbbrevTable(sections.Abbrev, abbrevOffset)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var stack []*DebugEntry = nil
+
+		for reader.pos < unitEnd && reader.err == nil {
+			var offset = reader.pos
+			var code = reader.uleb()
+
+			if code == 0 {
+				if len(stack) > 0 {
+					stack = stack[:len(stack)-1]
+				}
+
+				continue
+			}
+
+			abbrev, ok := abbrevs[code]
+
+			if !ok {
+				return nil, nil, fmt.Errorf("Unknown abbreviation %d at 0x%x in .debug_info", code, offset)
+			}
+
+			var entry = &DebugEntry{abbrev.tag, make(map[DW_AT]formValue), nil, offset}
+
+			for _, spec := range abbrev.attributes {
+				value, err := readForm(reader, spec.form, &unit, spec.implicitConst)
+
+				if err != nil {
+					return nil, nil, err
+				}
+
+				if value.address != nil {
+					addresses = append(addresses, *value.address)
+				}
+
+				entry.attributes[spec.at] = value
+			}
+
+			if len(stack) == 0 {
+				units = append(units, entry)
+			} else {
+				var parent = stack[len(stack)-1]
+				parent.Children = append(parent.Children, entry)
+			}
+
+			if abbrev.hasChildren {
+				stack = append(stack, entry)
+			}
+		}
+
+		if reader.err != nil {
+			return nil, nil, reader.err
+		}
+
+		reader.pos = unitEnd
+	}
+
+	return units, addresses, nil
+}
+
+func ParseDebugInfo(sections DebugInfoSections, byteOrder binary.ByteOrder) ([]*DebugEntry, error) {
+	units, _, err := walkDebugInfo(sections, byteOrder)
+	return units, err
+}
+
+func FindInfoAddresses(sections DebugInfoSections, byteOrder binary.ByteOrder) ([]AddressField, error) {
+	_, addresses, err := walkDebugInfo(sections, byteOrder)
+	return addresses, err
+}
+
+func FindArangesAddresses(debugAranges []byte, byteOrder binary.ByteOrder) ([]AddressField, error) {
+	var reader = newSectionReader(debugAranges, byteOrder)
+	var result []AddressField = nil
+
+	for reader.pos < len(reader.data) {
+		var unitStart = reader.pos
+		unitEnd, err := reader.unitLength()
+
+		if err != nil {
+			return nil, err
+		}
+
+		reader.u16() // version
+		reader.u32() // debug_info offset
+		var addressSize = int(reader.u8())
+		reader.u8() // segment size
+
+		if addressSize == 0 {
+			return nil, errTruncated
+		}
+
+		// tuples are aligned to twice the address size
+		var tupleSize = addressSize * 2
+		var misalign = (reader.pos - unitStart) % tupleSize
+
+		if misalign != 0 {
+			reader.take(tupleSize - misalign)
+		}
+
+		for reader.pos+tupleSize <= unitEnd && reader.err == nil {
+			var field = reader.addressField(addressSize)
+			var length = reader.sized(addressSize)
+
+			if field.Value == 0 && length == 0 {
+				break
+			}
+
+			result = append(result, field)
+		}
+
+		if reader.err != nil {
+			return nil, reader.err
+		}
+
+		reader.pos = unitEnd
+	}
+
+	return result, nil
+}
+
+// entries in .debug_ranges are relative to the base address of the
+// compile unit, only base address selection entries hold an address
+func FindRangesAddresses(debugRanges []byte, addressSize int, byteOrder binary.ByteOrder) ([]AddressField, error) {
+	var reader = newSectionReader(debugRanges, byteOrder)
+	var result []AddressField = nil
+	var baseSelection uint64 = 0xffffffff
+
+	if addressSize == 8 {
+		baseSelection = 0xffffffffffffffff
+	}
+
+	for reader.pos+addressSize*2 <= len(reader.data) {
+		var start = reader.sized(addressSize)
+		var field = reader.addressField(addressSize)
+
+		if start == baseSelection {
+			result = append(result, field)
+		}
+	}
+
+	return result, reader.err
+}
+
+// .debug_loc has the same entries as .debug_ranges with a
+// location expression after each one that isn't a base address
+func FindLocAddresses(debugLoc []byte, addressSize int, byteOrder binary.ByteOrder) ([]AddressField, error) {
+	var reader = newSectionReader(debugLoc, byteOrder)
+	var result []AddressField = nil
+	var baseSelection uint64 = 0xffffffff
+
+	if addressSize == 8 {
+		baseSelection = 0xffffffffffffffff
+	}
+
+	for reader.pos+addressSize*2 <= len(reader.data) && reader.err == nil {
+		var start = reader.sized(addressSize)
+		var field = reader.addressField(addressSize)
+
+		if start == baseSelection {
+			result = append(result, field)
+		} else if start != 0 || field.Value != 0 {
+			reader.take(int(reader.u16()))
+		}
+	}
+
+	return result, reader.err
+}
+
+// the addrx forms are indices into the table of addresses for their unit
+func FindAddrAddresses(debugAddr []byte, byteOrder binary.ByteOrder) ([]AddressField, error) {
+	var reader = newSectionReader(debugAddr, byteOrder)
+	var result []AddressField = nil
+
+	for reader.pos < len(reader.data) {
+		unitEnd, err := reader.unitLength()
+
+		if err != nil {
+			return nil, err
+		}
+
+		reader.u16() // version
+		var addressSize = int(reader.u8())
+		reader.u8() // segment selector size
+
+		if addressSize == 0 {
+			return nil, errTruncated
+		}
+
+		for reader.pos+addressSize <= unitEnd && reader.err == nil {
+			result = append(result, reader.addressField(addressSize))
+		}
+
+		if reader.err != nil {
+			return nil, reader.err
+		}
+
+		reader.pos = unitEnd
+	}
+
+	return result, nil
+}
+
+// the header of a unit in .debug_rnglists or .debug_loclists, the
+// offset table is skipped since the entries are read in order
+func readListsHeader(reader *sectionReader) (int, int, error) {
+	unitEnd, err := reader.unitLength()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reader.u16() // version
+	var addressSize = int(reader.u8())
+	reader.u8() // segment selector size
+	var offsetCount = reader.u32()
+	reader.take(int(offsetCount) * 4)
+
+	if addressSize == 0 || reader.err != nil {
+		return 0, 0, errTruncated
+	}
+
+	return unitEnd, addressSize, nil
+}
+
+// only base_address, start_end and start_length hold addresses, the
+// other entries are offsets from the base or indices into .debug_addr
+func FindRngListsAddresses(debugRngLists []byte, byteOrder binary.ByteOrder) ([]AddressField, error) {
+	var reader = newSectionReader(debugRngLists, byteOrder)
+	var result []AddressField = nil
+
+	for reader.pos < len(reader.data) {
+		unitEnd, addressSize, err := readListsHeader(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for reader.pos < unitEnd && reader.err == nil {
+			var kind = DW_RLE(reader.u8())
+
+			switch kind {
+			case DW_RLE_end_of_list:
+			case DW_RLE_base_addressx:
+				reader.uleb()
+			case DW_RLE_startx_endx, DW_RLE_startx_length, DW_RLE_offset_pair:
+				reader.uleb()
+				reader.uleb()
+			case DW_RLE_base_address:
+				result = append(result, reader.addressField(addressSize))
+			case DW_RLE_start_end:
+				result = append(result, reader.addressField(addressSize))
+				result = append(result, reader.addressField(addressSize))
+			case DW_RLE_start_length:
+				result = append(result, reader.addressField(addressSize))
+				reader.uleb()
+			default:
+				return nil, fmt.Errorf("Unknown range list entry 0x%x", uint8(kind))
+			}
+		}
+
+		if reader.err != nil {
+			return nil, reader.err
+		}
+
+		reader.pos = unitEnd
+	}
+
+	return result, nil
+}
+
+// like the range lists but every entry that covers a range
+// is followed by the length and bytes of its location
+func FindLocListsAddresses(debugLocLists []byte, byteOrder binary.ByteOrder) ([]AddressField, error) {
+	var reader = newSectionReader(debugLocLists, byteOrder)
+	var result []AddressField = nil
+
+	for reader.pos < len(reader.data) {
+		unitEnd, addressSize, err := readListsHeader(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for reader.pos < unitEnd && reader.err == nil {
+			var kind = DW_LLE(reader.u8())
+
+			switch kind {
+			case DW_LLE_end_of_list:
+				continue
+			case DW_LLE_base_addressx:
+				reader.uleb()
+				continue
+			case DW_LLE_base_address:
+				result = append(result, reader.addressField(addressSize))
+				continue
+			case DW_LLE_startx_endx, DW_LLE_startx_length, DW_LLE_offset_pair:
+				reader.uleb()
+				reader.uleb()
+			case DW_LLE_default_location:
+			case DW_LLE_start_end:
+				result = append(result, reader.addressField(addressSize))
+				result = append(result, reader.addressField(addressSize))
+			case DW_LLE_start_length:
+				result = append(result, reader.addressField(addressSize))
+				reader.uleb()
+			default:
+				return nil, fmt.Errorf("Unknown location list entry 0x%x", uint8(kind))
+			}
+
+			reader.take(int(reader.uleb()))
+		}
+
+		if reader.err != nil {
+			return nil, reader.err
+		}
+
+		reader.pos = unitEnd
+	}
+
+	return result, nil
+}
@@ -0,0 +1,320 @@
+package dwarf
+
+import (
+	"encoding/binary"
+	"path"
+)
+
+type lineProgramHeader struct {
+	version        uint16
+	minInstLength  int
+	defaultIsStmt  bool
+	lineBase       int
+	lineRange      int
+	opcodeBase     int
+	opcodeLengths  []uint8
+	files          []string
+	fileIndexStart int
+}
+
+func readLineFileEntries(reader *sectionReader, unit *unitInfo, directories []string) []string {
+	var formatCount = int(reader.u8())
+	var contentTypes = make([]uint64, formatCount)
+	var forms = make([]DW_FORM, formatCount)
+
+	for i := 0; i < formatCount; i++ {
+		contentTypes[i] = reader.uleb()
+		forms[i] = DW_FORM(reader.uleb())
+	}
+
+	var count = int(reader.uleb())
+	var result []string = nil
+
+	for i := 0; i < count && reader.err == nil; i++ {
+		var name = ""
+		var directory uint64 = 0
+
+		for j := range forms {
+			value, err := readForm(reader, forms[j], unit, 0)
+
+			if err != nil {
+				return result
+			}
+
+			switch contentTypes[j] {
+			case DW_LNCT_path:
+				name = value.str
+			case DW_LNCT_directory_index:
+				directory = value.number
+			}
+		}
+
+		if directories != nil && directory != 0 && directory < uint64(len(directories)) && !path.IsAbs(name) {
+			name = path.Join(directories[directory], name)
+		}
+
+		result = append(result, name)
+	}
+
+	return result
+}
+
+func readLineProgramHeader(reader *sectionReader, debugLineStr []byte) (*lineProgramHeader, int, error) {
+	var header lineProgramHeader
+	var unit = unitInfo{0, 4, nil, debugLineStr, 0}
+
+	header.version = reader.u16()
+	unit.version = header.version
+
+	if header.version >= 5 {
+		unit.addressSize = int(reader.u8())
+		reader.u8() // segment selector size
+	}
+
+	var headerLength = int(reader.u32())
+	var programStart = reader.pos + headerLength
+
+	header.minInstLength = int(reader.u8())
+
+	if header.version >= 4 {
+		reader.u8() // maximum operations per instruction
+	}
+
+	header.defaultIsStmt = reader.u8() != 0
+	header.lineBase = int(int8(reader.u8()))
+	header.lineRange = int(reader.u8())
+	header.opcodeBase = int(reader.u8())
+
+	if header.opcodeBase > 0 {
+		header.opcodeLengths = reader.take(header.opcodeBase - 1)
+	}
+
+	if header.version >= 5 {
+		var directories = readLineFileEntries(reader, &unit, nil)
+		header.files = readLineFileEntries(reader, &unit, directories)
+		header.fileIndexStart = 0
+	} else {
+		var directories = []string{""}
+
+		for {
+			var directory = reader.cstring()
+
+			if directory == "" || reader.err != nil {
+				break
+			}
+
+			directories = append(directories, directory)
+		}
+
+		for {
+			var name = reader.cstring()
+
+			if name == "" || reader.err != nil {
+				break
+			}
+
+			var directory = reader.uleb()
+			reader.uleb() // modification time
+			reader.uleb() // length
+
+			if directory != 0 && directory < uint64(len(directories)) && !path.IsAbs(name) {
+				name = path.Join(directories[directory], name)
+			}
+
+			header.files = append(header.files, name)
+		}
+
+		header.fileIndexStart = 1
+	}
+
+	if header.lineRange == 0 {
+		header.lineRange = 1
+	}
+
+	return &header, programStart, reader.err
+}
+
+func (header *lineProgramHeader) filename(file uint64) string {
+	var index = int(file) - header.fileIndexStart
+
+	if index < 0 || index >= len(header.files) {
+		return ""
+	}
+
+	return header.files[index]
+}
+
+// walks every line program in .debug_line calling emit for each row
+// of the line table and address for each DW_LNE_set_address operand
+func walkLinePrograms(
+	debugLine []byte,
+	debugLineStr []byte,
+	byteOrder binary.ByteOrder,
+	emit func(entry InstructionEntry, endSequence bool),
+	address func(field AddressField),
+) error {
+	var reader = newSectionReader(debugLine, byteOrder)
+
+	for reader.pos < len(reader.data) {
+		unitEnd, err := reader.unitLength()
+
+		if err != nil {
+			return err
+		}
+
+		header, programStart, err := readLineProgramHeader(reader, debugLineStr)
+
+		if err != nil {
+			return err
+		}
+
+		reader.pos = programStart
+
+		var state InstructionEntry
+		var file uint64 = 1
+
+		var reset = func() {
+			state = InstructionEntry{0, "", 1, 0, header.defaultIsStmt, false, false}
+			file = 1
+		}
+
+		var row = func(endSequence bool) {
+			state.filename = header.filename(file)
+
+			if emit != nil {
+				emit(state, endSequence)
+			}
+
+			state.isBlock = false
+			state.prologueEnd = false
+		}
+
+		reset()
+
+		for reader.pos < unitEnd && reader.err == nil {
+			var opcode = int(reader.u8())
+
+			if opcode >= header.opcodeBase {
+				var adjusted = opcode - header.opcodeBase
+				state.address += (adjusted / header.lineRange) * header.minInstLength
+				state.line += header.lineBase + adjusted%header.lineRange
+				row(false)
+				continue
+			}
+
+			switch opcode {
+			case 0:
+				var length = int(reader.uleb())
+				var next = reader.pos + length
+				var extended = reader.u8()
+
+				switch extended {
+				case DW_LNE_end_sequence:
+					row(true)
+					reset()
+				case DW_LNE_set_address:
+					var field = reader.addressField(length - 1)
+					state.address = int(field.Value)
+
+					if address != nil {
+						address(field)
+					}
+				}
+
+				reader.pos = next
+			case DW_LNS_copy:
+				row(false)
+			case DW_LNS_advance_pc:
+				state.address += int(reader.uleb()) * header.minInstLength
+			case DW_LNS_advance_line:
+				state.line += int(reader.sleb())
+			case DW_LNS_set_file:
+				file = reader.uleb()
+			case DW_LNS_set_column:
+				state.col = int(reader.uleb())
+			case DW_LNS_negate_stmt:
+				state.isStatement = !state.isStatement
+			case DW_LNS_set_basic_block:
+				state.isBlock = true
+			case DW_LNS_set_prologue_end:
+				state.prologueEnd = true
+			case DW_LNS_const_add_pc:
+				state.address += ((255 - header.opcodeBase) / header.lineRange) * header.minInstLength
+			case DW_LNS_fixed_advance_pc:
+				state.address += int(reader.u16())
+			default:
+				// skip the operands of opcodes we don't care about
+				if opcode-1 < len(header.opcodeLengths) {
+					for i := 0; i < int(header.opcodeLengths[opcode-1]); i++ {
+						reader.uleb()
+					}
+				}
+			}
+		}
+
+		if reader.err != nil {
+			return reader.err
+		}
+
+		reader.pos = unitEnd
+	}
+
+	return nil
+}
+
+// returns the rows of every line table, end of sequence rows are left out
+func ParseDebugLines(debugLine []byte, debugLineStr []byte, byteOrder binary.ByteOrder) ([]InstructionEntry, error) {
+	var result []InstructionEntry = nil
+
+	err := walkLinePrograms(debugLine, debugLineStr, byteOrder, func(entry InstructionEntry, endSequence bool) {
+		if !endSequence {
+			result = append(result, entry)
+		}
+	}, nil)
+
+	return result, err
+}
+
+// returns the rows of the line table at offset, such as the DW_AT_stmt_list of a unit
+func ParseDebugLinesAt(debugLine []byte, debugLineStr []byte, offset int, byteOrder binary.ByteOrder) ([]InstructionEntry, error) {
+	var reader = newSectionReader(debugLine, byteOrder)
+	reader.pos = offset
+
+	unitEnd, err := reader.unitLength()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseDebugLines(debugLine[offset:unitEnd], debugLineStr, byteOrder)
+}
+
+// the name of a file in the line table at offset, as used by DW_AT_call_file
+func LineProgramFilename(debugLine []byte, debugLineStr []byte, offset int, file uint64, byteOrder binary.ByteOrder) string {
+	var reader = newSectionReader(debugLine, byteOrder)
+	reader.pos = offset
+
+	_, err := reader.unitLength()
+
+	if err != nil {
+		return ""
+	}
+
+	header, _, err := readLineProgramHeader(reader, debugLineStr)
+
+	if err != nil {
+		return ""
+	}
+
+	return header.filename(file)
+}
+
+func FindLineAddresses(debugLine []byte, byteOrder binary.ByteOrder) ([]AddressField, error) {
+	var result []AddressField = nil
+
+	err := walkLinePrograms(debugLine, nil, byteOrder, nil, func(field AddressField) {
+		result = append(result, field)
+	})
+
+	return result, err
+}
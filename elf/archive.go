@@ -0,0 +1,153 @@
+package elf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// holds a serialized elf file in memory
+type memoryWriter struct {
+	data     []byte
+	position int64
+}
+
+func (writer *memoryWriter) Write(p []byte) (n int, err error) {
+	var end = writer.position + int64(len(p))
+
+	if end > int64(len(writer.data)) {
+		writer.data = append(writer.data, make([]byte, end-int64(len(writer.data)))...)
+	}
+
+	copy(writer.data[writer.position:], p)
+	writer.position = end
+
+	return len(p), nil
+}
+
+func (writer *memoryWriter) Seek(offset int64, whence int) (int64, error) {
+	var position = offset
+
+	switch whence {
+	case os.SEEK_CUR:
+		position += writer.position
+	case os.SEEK_END:
+		position += int64(len(writer.data))
+	}
+
+	if position < 0 {
+		return 0, errors.New("Seek before the start of the file")
+	}
+
+	writer.position = position
+
+	return position, nil
+}
+
+func SerializeToBytes(elfFile *ElfFile) ([]byte, error) {
+	var writer memoryWriter
+
+	err := Serialize(&writer, elfFile)
+
+	return writer.data, err
+}
+
+type ArchiveMember struct {
+	Name string
+	Data []byte
+	// global symbols defined by the member for the archive index
+	Symbols []string
+}
+
+const archiveMagic = "!<arch>\n"
+const archiveHeaderSize = 60
+
+func writeArchiveHeader(writer io.Writer, name string, size int) {
+	// no dates or owners so the same inputs give the same archive
+	fmt.Fprintf(writer, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, 0, 0, 0, "644", size)
+}
+
+func writeArchiveMember(writer io.Writer, name string, data []byte) {
+	writeArchiveHeader(writer, name, len(data))
+	writer.Write(data)
+
+	if len(data)%2 != 0 {
+		writer.Write([]byte{'\n'})
+	}
+}
+
+func paddedMemberSize(size int) int {
+	return archiveHeaderSize + size + size%2
+}
+
+// writes a gnu style ar archive with a symbol index
+// so the linker can find which member to pull in
+func WriteArchive(writer io.Writer, members []ArchiveMember) error {
+	var longNames bytes.Buffer
+	var memberNames []string = nil
+
+	for _, member := range members {
+		// names are terminated with / so they can contain spaces
+		if len(member.Name)+1 > 16 {
+			memberNames = append(memberNames, fmt.Sprintf("/%d", longNames.Len()))
+			longNames.WriteString(member.Name + "/\n")
+		} else {
+			memberNames = append(memberNames, member.Name+"/")
+		}
+	}
+
+	var symbolCount = 0
+	var symbolNamesSize = 0
+
+	for _, member := range members {
+		symbolCount += len(member.Symbols)
+
+		for _, symbol := range member.Symbols {
+			symbolNamesSize += len(symbol) + 1
+		}
+	}
+
+	var indexSize = 4 + symbolCount*4 + symbolNamesSize
+	var offset = len(archiveMagic) + paddedMemberSize(indexSize)
+
+	if longNames.Len() > 0 {
+		offset += paddedMemberSize(longNames.Len())
+	}
+
+	var index bytes.Buffer
+	var names bytes.Buffer
+
+	binary.Write(&index, binary.BigEndian, uint32(symbolCount))
+
+	for _, member := range members {
+		for _, symbol := range member.Symbols {
+			binary.Write(&index, binary.BigEndian, uint32(offset))
+			names.WriteString(symbol)
+			names.WriteByte(0)
+		}
+
+		offset += paddedMemberSize(len(member.Data))
+	}
+
+	index.Write(names.Bytes())
+
+	var result bytes.Buffer
+
+	result.WriteString(archiveMagic)
+	writeArchiveMember(&result, "/", index.Bytes())
+
+	if longNames.Len() > 0 {
+		writeArchiveMember(&result, "//", longNames.Bytes())
+	}
+
+	for memberIndex, member := range members {
+		writeArchiveMember(&result, memberNames[memberIndex], member.Data)
+	}
+
+	_, err := writer.Write(result.Bytes())
+
+	return err
+}
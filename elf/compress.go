@@ -0,0 +1,156 @@
+package elf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+const SHF_COMPRESSED SectionHeaderFlags = 0x800
+
+type CompressionType uint32
+
+const (
+	ELFCOMPRESS_NONE CompressionType = 0
+	ELFCOMPRESS_ZLIB CompressionType = 1
+	ELFCOMPRESS_ZSTD CompressionType = 2
+)
+
+type DebugCompression int
+
+const (
+	DebugCompressionNone DebugCompression = iota
+	// SHF_COMPRESSED with an Elf32_Chdr header
+	DebugCompressionZlib
+	// legacy .zdebug_ sections understood by older gdb
+	DebugCompressionZlibGnu
+)
+
+const compressionHeaderSize = 12
+const zdebugMagic = "ZLIB"
+
+func deflate(data []byte) []byte {
+	var buffer bytes.Buffer
+	var writer = zlib.NewWriter(&buffer)
+	writer.Write(data)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+func compressSection(section *ElfSection, compression DebugCompression, byteOrder binary.ByteOrder) {
+	var buffer bytes.Buffer
+
+	if compression == DebugCompressionZlib {
+		var chType = ELFCOMPRESS_ZLIB
+		var chSize = uint32(len(section.Data))
+		var chAlign = section.AddressAlign
+		binary.Write(&buffer, byteOrder, &chType)
+		binary.Write(&buffer, byteOrder, &chSize)
+		binary.Write(&buffer, byteOrder, &chAlign)
+		buffer.Write(deflate(section.Data))
+
+		section.Flags |= SHF_COMPRESSED
+		section.AddressAlign = 4
+	} else {
+		// the legacy header always stores the size big endian
+		var size = uint64(len(section.Data))
+		buffer.WriteString(zdebugMagic)
+		binary.Write(&buffer, binary.BigEndian, &size)
+		buffer.Write(deflate(section.Data))
+
+		section.Name = ".z" + section.Name[1:]
+	}
+
+	section.Data = buffer.Bytes()
+}
+
+func compressDebugSections(elfFile *ElfFile, byteOrder binary.ByteOrder) {
+	if elfFile.DebugCompression == DebugCompressionNone {
+		return
+	}
+
+	for index := range elfFile.Sections {
+		var section = &elfFile.Sections[index]
+
+		if section.Flags&SHF_COMPRESSED != 0 || section.Type == SHT_NOBITS {
+			continue
+		}
+
+		if strings.HasPrefix(section.Name, ".debug_") {
+			compressSection(section, elfFile.DebugCompression, byteOrder)
+		} else if elfFile.DebugCompression == DebugCompressionZlibGnu && strings.HasPrefix(section.Name, ".rel.debug_") {
+			// keep relocations pointing at the renamed section
+			section.Name = ".rel.z" + section.Name[5:]
+		}
+	}
+}
+
+func decompressSection(section *ElfSection, byteOrder binary.ByteOrder) error {
+	if section.Flags&SHF_COMPRESSED != 0 {
+		if len(section.Data) < compressionHeaderSize {
+			return errors.New("Compressed section " + section.Name + " is missing its header")
+		}
+
+		var chType = CompressionType(byteOrder.Uint32(section.Data[0:4]))
+		var chSize = byteOrder.Uint32(section.Data[4:8])
+		var chAlign = byteOrder.Uint32(section.Data[8:12])
+
+		if chType != ELFCOMPRESS_ZLIB {
+			return errors.New("Section " + section.Name + " uses an unsupported compression type")
+		}
+
+		data, err := inflate(section.Data[compressionHeaderSize:])
+
+		if err != nil {
+			return err
+		}
+
+		if uint32(len(data)) != chSize {
+			return errors.New("Section " + section.Name + " did not decompress to the expected size")
+		}
+
+		section.Data = data
+		section.Size = chSize
+		section.AddressAlign = chAlign
+		section.Flags &^= SHF_COMPRESSED
+	} else if strings.HasPrefix(section.Name, ".zdebug_") {
+		if len(section.Data) < 12 || string(section.Data[0:4]) != zdebugMagic {
+			return errors.New("Section " + section.Name + " is missing the ZLIB header")
+		}
+
+		var size = binary.BigEndian.Uint64(section.Data[4:12])
+
+		data, err := inflate(section.Data[12:])
+
+		if err != nil {
+			return err
+		}
+
+		if uint64(len(data)) != size {
+			return errors.New("Section " + section.Name + " did not decompress to the expected size")
+		}
+
+		section.Data = data
+		section.Size = uint32(size)
+		section.Name = "." + section.Name[2:]
+	} else if strings.HasPrefix(section.Name, ".rel.zdebug_") {
+		section.Name = ".rel." + section.Name[6:]
+	}
+
+	return nil
+}
@@ -164,14 +164,20 @@ type ElfSection struct {
 }
 
 type ElfFile struct {
-	Header   ElfHeader
-	Sections []ElfSection
+	Header           ElfHeader
+	Sections         []ElfSection
+	DebugCompression DebugCompression
 
 	symbols    []ElfSymbol
 	stringData []byte
 }
 
 func (file *ElfFile) AddString(value string) int {
+	if len(file.stringData) == 0 {
+		// offset 0 is reserved for the empty string
+		file.stringData = make([]byte, 1)
+	}
+
 	newData, result := AddStringToSection(file.stringData, value)
 
 	file.stringData = newData
@@ -272,6 +278,12 @@ const (
 	STT_HIPROC  SymbolType = 15
 )
 
+const (
+	SHN_UNDEF  uint16 = 0
+	SHN_ABS    uint16 = 0xfff1
+	SHN_COMMON uint16 = 0xfff2
+)
+
 func BuildSymbol(
 	name string,
 	value uint32,
@@ -298,6 +310,42 @@ func (elfFile *ElfFile) AddSymbols(symbols []ElfSymbol, byteOrder binary.ByteOrd
 	}
 }
 
+// the index the symbol will have once locals are moved before globals
+// preferring a global symbol when a local one has the same name
+func (elfFile *ElfFile) SymbolIndex(name string) int {
+	var localCount = 0
+	var localIndex = -1
+	var globalIndex = -1
+
+	for _, symbol := range elfFile.symbols {
+		if (symbol.Info >> 4) == uint8(STB_LOCAL) {
+			if symbol.Name == name && localIndex == -1 {
+				localIndex = localCount
+			}
+
+			localCount++
+		}
+	}
+
+	var globalCount = 0
+
+	for _, symbol := range elfFile.symbols {
+		if (symbol.Info >> 4) != uint8(STB_LOCAL) {
+			if symbol.Name == name && globalIndex == -1 {
+				globalIndex = localCount + globalCount
+			}
+
+			globalCount++
+		}
+	}
+
+	if globalIndex != -1 {
+		return globalIndex
+	}
+
+	return localIndex
+}
+
 func rebuildElfSymbolsAndStrings(elfFile *ElfFile, byteOrder binary.ByteOrder) int {
 	var stringIndex = elfFile.FindSectionIndex(".strtab")
 
@@ -321,19 +369,30 @@ func rebuildElfSymbolsAndStrings(elfFile *ElfFile, byteOrder binary.ByteOrder) i
 
 	var buffer bytes.Buffer
 
-	var info = 0
+	// local symbols have to come before any global symbols
+	var ordered []ElfSymbol = nil
+
+	for _, symbol := range elfFile.symbols {
+		if (symbol.Info >> 4) == uint8(STB_LOCAL) {
+			ordered = append(ordered, symbol)
+		}
+	}
 
-	for index, symbol := range elfFile.symbols {
+	var info = len(ordered)
+
+	for _, symbol := range elfFile.symbols {
+		if (symbol.Info >> 4) != uint8(STB_LOCAL) {
+			ordered = append(ordered, symbol)
+		}
+	}
+
+	for _, symbol := range ordered {
 		binary.Write(&buffer, byteOrder, &symbol.nameOffset)
 		binary.Write(&buffer, byteOrder, &symbol.Value)
 		binary.Write(&buffer, byteOrder, &symbol.Size)
 		binary.Write(&buffer, byteOrder, &symbol.Info)
 		binary.Write(&buffer, byteOrder, &symbol.Other)
 		binary.Write(&buffer, byteOrder, &symbol.SHIndex)
-
-		if (symbol.Info >> 4) == uint8(STB_LOCAL) {
-			info = index + 1
-		}
 	}
 
 	var symbolIndex = elfFile.FindSectionIndex(".symtab")
@@ -0,0 +1,65 @@
+package elf
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const NT_GNU_BUILD_ID = 3
+
+func BuildDebugLinkSection(debugFilename string, crc uint32, byteOrder binary.ByteOrder) ElfSection {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(debugFilename)
+	buffer.WriteByte(0)
+
+	for buffer.Len()%4 != 0 {
+		buffer.WriteByte(0)
+	}
+
+	binary.Write(&buffer, byteOrder, &crc)
+
+	return BuildElfSection(
+		".gnu_debuglink",
+		SHT_PROGBITS,
+		0,
+		0,
+		0,
+		0,
+		4,
+		0,
+		buffer.Bytes(),
+	)
+}
+
+func BuildBuildIdSection(buildId []byte, byteOrder binary.ByteOrder) ElfSection {
+	var buffer bytes.Buffer
+
+	var name = []byte("GNU\x00")
+	var nameSize = uint32(len(name))
+	var descSize = uint32(len(buildId))
+	var noteType uint32 = NT_GNU_BUILD_ID
+
+	binary.Write(&buffer, byteOrder, &nameSize)
+	binary.Write(&buffer, byteOrder, &descSize)
+	binary.Write(&buffer, byteOrder, &noteType)
+	buffer.Write(name)
+	buffer.Write(buildId)
+
+	for buffer.Len()%4 != 0 {
+		buffer.WriteByte(0)
+	}
+
+	// left unallocated so the id doesn't end up in the rom
+	return BuildElfSection(
+		".note.gnu.build-id",
+		SHT_NOTE,
+		0,
+		0,
+		0,
+		0,
+		4,
+		0,
+		buffer.Bytes(),
+	)
+}
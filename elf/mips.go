@@ -0,0 +1,115 @@
+package elf
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	SHT_MIPS_DEBUG    SectionType = 0x70000005
+	SHT_MIPS_REGINFO  SectionType = 0x70000006
+	SHT_MIPS_ABIFLAGS SectionType = 0x7000002A
+)
+
+const (
+	EF_MIPS_NOREORDER  uint32 = 0x00000001
+	EF_MIPS_PIC        uint32 = 0x00000002
+	EF_MIPS_CPIC       uint32 = 0x00000004
+	EF_MIPS_ABI2       uint32 = 0x00000020
+	EF_MIPS_32BITMODE  uint32 = 0x00000100
+	EF_MIPS_ABI_O32    uint32 = 0x00001000
+	EF_MIPS_ABI_O64    uint32 = 0x00002000
+	EF_MIPS_ARCH_1     uint32 = 0x00000000
+	EF_MIPS_ARCH_2     uint32 = 0x10000000
+	EF_MIPS_ARCH_3     uint32 = 0x20000000
+	EF_MIPS_ARCH_4     uint32 = 0x30000000
+	EF_MIPS_ARCH_MASK  uint32 = 0xF0000000
+	EF_MIPS_ABI_MASK   uint32 = 0x0000F000
+	EF_MIPS_MACH_MASK  uint32 = 0x00FF0000
+	EF_MIPS_FLAGS_MASK uint32 = 0x000000FF
+)
+
+const (
+	AFL_REG_NONE = 0
+	AFL_REG_32   = 1
+	AFL_REG_64   = 2
+)
+
+const (
+	Val_GNU_MIPS_ABI_FP_ANY    = 0
+	Val_GNU_MIPS_ABI_FP_DOUBLE = 1
+	Val_GNU_MIPS_ABI_FP_SOFT   = 3
+)
+
+func BuildRegInfoSection(byteOrder binary.ByteOrder) ElfSection {
+	var buffer bytes.Buffer
+
+	// register masks are left clear since rsp code
+	// does not use the cpu registers
+	var gprMask uint32 = 0
+	var cprMask [4]uint32
+	var gpValue int32 = 0
+	binary.Write(&buffer, byteOrder, &gprMask)
+	binary.Write(&buffer, byteOrder, &cprMask)
+	binary.Write(&buffer, byteOrder, &gpValue)
+
+	return BuildElfSection(
+		".reginfo",
+		SHT_MIPS_REGINFO,
+		SHF_ALLOC,
+		0,
+		0,
+		0,
+		4,
+		24,
+		buffer.Bytes(),
+	)
+}
+
+func BuildAbiFlagsSection(isaLevel uint8, gprSize uint8, fpAbi uint8, byteOrder binary.ByteOrder) ElfSection {
+	var buffer bytes.Buffer
+
+	var version uint16 = 0
+	binary.Write(&buffer, byteOrder, &version)
+	buffer.WriteByte(isaLevel)
+	buffer.WriteByte(0) // isa revision
+	buffer.WriteByte(gprSize)
+	buffer.WriteByte(0) // cpr1 size
+	buffer.WriteByte(0) // cpr2 size
+	buffer.WriteByte(fpAbi)
+
+	var zero uint32 = 0
+	binary.Write(&buffer, byteOrder, &zero) // isa extension
+	binary.Write(&buffer, byteOrder, &zero) // ases
+	binary.Write(&buffer, byteOrder, &zero) // flags1
+	binary.Write(&buffer, byteOrder, &zero) // flags2
+
+	return BuildElfSection(
+		".MIPS.abiflags",
+		SHT_MIPS_ABIFLAGS,
+		SHF_ALLOC,
+		0,
+		0,
+		0,
+		8,
+		24,
+		buffer.Bytes(),
+	)
+}
+
+// offsets in the ecoff symbolic header are relative to the
+// start of the file so they can only be filled in once the
+// location of the section is known
+func relocateMDebug(data []byte, fileOffset uint32, byteOrder binary.ByteOrder) []byte {
+	var result = make([]byte, len(data))
+	copy(result, data)
+
+	// cbLineOffset through cbExtOffset, each is preceded by its count
+	for offset := 12; offset+4 <= len(result) && offset < 0x60; offset += 8 {
+		if byteOrder.Uint32(result[offset-4:]) != 0 {
+			byteOrder.PutUint32(result[offset:], byteOrder.Uint32(result[offset:])+fileOffset)
+		}
+	}
+
+	return result
+}
@@ -1,6 +1,7 @@
 package elf
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"os"
@@ -29,11 +30,12 @@ func deserializeSection(file SeekableReader, section *ElfSection, byteOrder bina
 		return err
 	}
 
-	file.Seek(int64(section.Offset), os.SEEK_SET)
-
 	section.Data = make([]byte, section.Size)
 
-	file.Read(section.Data)
+	if section.Type != SHT_NOBITS {
+		file.Seek(int64(section.Offset), os.SEEK_SET)
+		file.Read(section.Data)
+	}
 
 	file.Seek(prevSection, os.SEEK_SET)
 
@@ -109,5 +111,78 @@ func ParseElf(file SeekableReader) (*ElfFile, error) {
 		}
 	}
 
+	for i := range result.Sections {
+		err = decompressSection(&result.Sections[i], byteOrder)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &result, nil
 }
+
+func (header *ElfHeader) Type() ElfType {
+	return header.eType
+}
+
+func (header *ElfHeader) Machine() ElfMachine {
+	return header.eMachine
+}
+
+func (header *ElfHeader) Flags() uint32 {
+	return header.eFlags
+}
+
+func (header *ElfHeader) ByteOrder() binary.ByteOrder {
+	if header.eIdent[EI_DATA] == byte(EI_DATA_LITTLE_ENDIAN) {
+		return binary.LittleEndian
+	}
+
+	return binary.BigEndian
+}
+
+func (symbol *ElfSymbol) Binding() SymbolBinding {
+	return SymbolBinding(symbol.Info >> 4)
+}
+
+func (symbol *ElfSymbol) Type() SymbolType {
+	return SymbolType(symbol.Info & 0xF)
+}
+
+// reads the symbols out of the .symtab section of a parsed file
+func (elfFile *ElfFile) ReadSymbols() ([]ElfSymbol, error) {
+	var symbolIndex = elfFile.FindSectionIndex(".symtab")
+
+	if symbolIndex == -1 {
+		return nil, nil
+	}
+
+	var symTab = &elfFile.Sections[symbolIndex]
+
+	if int(symTab.Link) >= len(elfFile.Sections) {
+		return nil, errors.New("Symbol table links to a missing string table")
+	}
+
+	var strTab = &elfFile.Sections[symTab.Link]
+	var byteOrder = elfFile.Header.ByteOrder()
+	var reader = bytes.NewReader(symTab.Data)
+	var result []ElfSymbol = nil
+
+	for reader.Len() >= 16 {
+		var symbol ElfSymbol
+
+		binary.Read(reader, byteOrder, &symbol.nameOffset)
+		binary.Read(reader, byteOrder, &symbol.Value)
+		binary.Read(reader, byteOrder, &symbol.Size)
+		binary.Read(reader, byteOrder, &symbol.Info)
+		binary.Read(reader, byteOrder, &symbol.Other)
+		binary.Read(reader, byteOrder, &symbol.SHIndex)
+
+		symbol.Name = GetString(strTab, symbol.nameOffset)
+
+		result = append(result, symbol)
+	}
+
+	return result, nil
+}
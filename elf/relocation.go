@@ -22,6 +22,7 @@ const (
 	R_MIPS_PC16    RelocationType = 10
 	R_MIPS_CALL16  RelocationType = 11
 	R_MIPS_GPREL32 RelocationType = 12
+	R_MIPS_64      RelocationType = 18
 )
 
 type RelocationEntry struct {
@@ -74,3 +75,11 @@ func (builder *RelocationBuilder) AddOffset(offset uint32) {
 		builder.entries[index].Offset += offset
 	}
 }
+
+// adds the entries of another builder that were
+// generated for data placed at offset
+func (builder *RelocationBuilder) Append(other *RelocationBuilder, offset uint32) {
+	for _, entry := range other.entries {
+		builder.entries = append(builder.entries, RelocationEntry{entry.Offset + offset, entry.SymbolName, entry.Type})
+	}
+}
@@ -1,6 +1,7 @@
 package elf
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"os"
@@ -15,30 +16,24 @@ type SeekableWriter interface {
 
 const sectionHeaderStringName = ".shstrtab"
 
+// strings can share the end of a longer string but
+// have to stop at the same null terminator
 func FindStringIndex(data []byte, str string) int {
-	var current int = 1
-	var stringIndex int = 0
-
-	var stringAsBytes = []byte(str)
-
 	if str == "" {
 		return 0
 	}
 
-	for current < len(data) {
-		if stringAsBytes[stringIndex] == data[current+stringIndex] {
-			stringIndex++
+	if len(data) < 1 {
+		return -1
+	}
 
-			if stringIndex == len(stringAsBytes) {
-				return current
-			}
-		} else {
-			current++
-			stringIndex = 0
-		}
+	var index = bytes.Index(data[1:], append([]byte(str), 0))
+
+	if index == -1 {
+		return -1
 	}
 
-	return -1
+	return index + 1
 }
 
 func AddStringToSection(data []byte, value string) ([]byte, int) {
@@ -131,6 +126,8 @@ func Serialize(writer SeekableWriter, elfFile *ElfFile) error {
 		return errors.New("Unrecognized data type")
 	}
 
+	compressDebugSections(elfFile, byteOrder)
+
 	var symbolIndex = rebuildElfSymbolsAndStrings(elfFile, byteOrder)
 	rebuildSectionHeaders(elfFile)
 
@@ -153,6 +150,11 @@ func Serialize(writer SeekableWriter, elfFile *ElfFile) error {
 		if section.Type == SHT_NULL {
 			section.Size = 0
 			section.Offset = 0
+		} else if section.Type == SHT_NOBITS {
+			// keeps the size of the data without writing it
+			section.Size = uint32(len(section.Data))
+			currentLocation, _ := writer.Seek(0, os.SEEK_CUR)
+			section.Offset = uint32(currentLocation)
 		} else {
 			section.Size = uint32(len(section.Data))
 			currentLocation, _ := writer.Seek(0, os.SEEK_CUR)
@@ -167,7 +169,12 @@ func Serialize(writer SeekableWriter, elfFile *ElfFile) error {
 			}
 
 			section.Offset = uint32(currentLocation)
-			writer.Write(section.Data)
+
+			if section.Type == SHT_MIPS_DEBUG {
+				writer.Write(relocateMDebug(section.Data, section.Offset, byteOrder))
+			} else {
+				writer.Write(section.Data)
+			}
 		}
 	}
 
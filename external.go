@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+// a word in DMEM the linker fills in with the address of a symbol
+type ExternalReference struct {
+	Offset uint32
+	Symbol string
+}
+
+// each line is offset symbol [addend] where offset is from the
+// start of DMEM or an SP memory address like 0x04000010
+func parseExternalReferences(filename string, input string, data []byte) ([]ExternalReference, error) {
+	var result []ExternalReference = nil
+	var used = make(map[uint32]int)
+
+	for index, line := range splitLines(input) {
+		var lineNumber = index + 1
+		line = strings.TrimSpace(line)
+
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		parts, err := tokenizeLine(line)
+
+		if err != nil {
+			return nil, createDiagnostic(filename, lineNumber, "%s", err.Error())
+		}
+
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, createDiagnostic(filename, lineNumber, "expected offset symbol [addend]")
+		}
+
+		address, err := parseMaybeHex(parts[0], 64)
+
+		if err != nil || address < 0 || address > 0xFFFFFFFF {
+			return nil, createDiagnostic(filename, lineNumber, "invalid offset '%s'", parts[0])
+		}
+
+		offset, isText, ok := classifyRspAddress(uint32(address))
+
+		if !ok || isText {
+			return nil, createDiagnostic(filename, lineNumber, "offset 0x%X is not in DMEM", address)
+		}
+
+		if offset%4 != 0 {
+			return nil, createDiagnostic(filename, lineNumber, "offset 0x%X is not word aligned", offset)
+		}
+
+		if int(offset)+4 > len(data) {
+			return nil, createDiagnostic(filename, lineNumber, "offset 0x%X is past the end of the 0x%X bytes of DMEM", offset, len(data))
+		}
+
+		previous, exists := used[offset]
+
+		if exists {
+			return nil, createDiagnostic(filename, lineNumber, "offset 0x%X is already relocated on line %d", offset, previous)
+		}
+
+		if len(parts) == 3 {
+			addend, err := parseMaybeHex(parts[2], 64)
+
+			if err != nil || addend < -0x80000000 || addend > 0xFFFFFFFF {
+				return nil, createDiagnostic(filename, lineNumber, "invalid addend '%s'", parts[2])
+			}
+
+			// a REL relocation keeps the addend in the word being relocated
+			binary.BigEndian.PutUint32(data[offset:], uint32(addend))
+		}
+
+		used[offset] = lineNumber
+		result = append(result, ExternalReference{offset, parts[1]})
+	}
+
+	return result, nil
+}
+
+func readExternalReferences(input string, microcode *MicrocodeInput) error {
+	var filename = input + ".relocs"
+
+	if !fileExists(filename) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return err
+	}
+
+	microcode.External, err = parseExternalReferences(filename, string(data), microcode.Data)
+
+	return err
+}
+
+// symbols the microcode refers to but doesn't define
+func buildUndefinedSymbols(elfFile *elf.ElfFile, microcodes []*Microcode) []elf.ElfSymbol {
+	var result []elf.ElfSymbol = nil
+	var added = make(map[string]bool)
+
+	for _, microcode := range microcodes {
+		for _, reference := range microcode.Input.External {
+			if added[reference.Symbol] || elfFile.SymbolIndex(reference.Symbol) != -1 {
+				continue
+			}
+
+			added[reference.Symbol] = true
+			result = append(result, elf.BuildSymbol(reference.Symbol, 0, 0, elf.STB_GLOBAL, elf.STT_NOTYPE, 0, elf.SHN_UNDEF))
+		}
+	}
+
+	return result
+}
+
+// has to run after every symbol is added so the indices are final
+func appendExternalRelocations(elfFile *elf.ElfFile, microcodes []*Microcode) {
+	for _, microcode := range microcodes {
+		if len(microcode.Input.External) == 0 {
+			continue
+		}
+
+		var relocations = elf.NewRelocationBuilder()
+		var symbolMapping = make(map[string]uint32)
+
+		for _, reference := range microcode.Input.External {
+			symbolMapping[reference.Symbol] = uint32(elfFile.SymbolIndex(reference.Symbol))
+			relocations.AddEntry(reference.Offset, reference.Symbol, elf.R_MIPS_32)
+		}
+
+		elfFile.Sections = append(elfFile.Sections, relocations.ToElfSection(microcode.DataSection, symbolMapping, binary.BigEndian))
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+const extractCommand = "extract"
+
+type extractArgs struct {
+	input  string
+	output string
+	name   string
+}
+
+func parseExtractArgs(args []string) (*extractArgs, error) {
+	var result extractArgs
+
+	if len(args) == 0 {
+		return nil, errors.New(`rsp2dwarf extract [-o output] [-n name] input
+	-o    the base name of the files to write, defaults to input without its extension
+	      writes output, output.dat, output.dbg and output.sym
+	-n    the link name of the microcode or overlay to extract, needed
+	      when the object holds more than one microcode`)
+	}
+
+	for i := 0; i < len(args); i++ {
+		var arg = args[i]
+
+		if arg[0] == '-' {
+			if arg == "-o" {
+				if i+1 >= len(args) {
+					return nil, errors.New("-o flag requires a parameter")
+				} else {
+					result.output = args[i+1]
+					i++
+				}
+			} else if arg == "-n" {
+				if i+1 >= len(args) {
+					return nil, errors.New("-n flag requires a parameter")
+				} else {
+					result.name = args[i+1]
+					i++
+				}
+			}
+		} else {
+			if result.input != "" {
+				return nil, errors.New("Only one input file is allowed")
+			} else {
+				result.input = arg
+			}
+		}
+	}
+
+	if result.input == "" {
+		return nil, errors.New("An input file is required")
+	}
+
+	if result.output == "" {
+		result.output = strings.TrimSuffix(result.input, path.Ext(result.input))
+
+		if result.output == result.input {
+			result.output = result.input + ".bin"
+		}
+	}
+
+	return &result, nil
+}
+
+func readSectionContents(elfFile *elf.ElfFile, name string, filename string) ([]byte, uint32, error) {
+	var index = elfFile.FindSectionIndex(name)
+
+	if index == -1 {
+		return nil, 0, nil
+	}
+
+	var section = &elfFile.Sections[index]
+
+	if section.Type == elf.SHT_NOBITS {
+		return nil, 0, errors.New(filename + " only has debug info, " + name + " has no contents")
+	}
+
+	return section.Data, section.Address, nil
+}
+
+// the symbols buildElf adds for the linker aren't part of the .dbg file
+func findLinkSymbols(symbols []elf.ElfSymbol, textAddress uint32, textSize uint32) map[string]bool {
+	var byName = make(map[string]elf.ElfSymbol)
+	var result = make(map[string]bool)
+
+	for _, symbol := range symbols {
+		byName[symbol.Name] = symbol
+	}
+
+	for _, symbol := range symbols {
+		if !strings.HasSuffix(symbol.Name, "TextStart") || symbol.Value != textAddress || symbol.Binding() != elf.STB_GLOBAL {
+			continue
+		}
+
+		var linkName = strings.TrimSuffix(symbol.Name, "TextStart")
+		textEnd, ok := byName[linkName+"TextEnd"]
+
+		if ok && textEnd.Value == textAddress+textSize {
+			result[linkName+"TextStart"] = true
+			result[linkName+"TextEnd"] = true
+			result[linkName+"DataStart"] = true
+			result[linkName+"DataEnd"] = true
+		}
+	}
+
+	return result
+}
+
+// the sections extract writes out, a microcode on its own is in .text and
+// .data, when an object holds several each has .text.<name> and .data.<name>
+// and an overlay only has text in .ovly.<name> that runs at loadAddress
+type extractSections struct {
+	text        string
+	data        string
+	loadAddress uint32
+}
+
+func findExtractSections(elfFile *elf.ElfFile, elfSymbols []elf.ElfSymbol, name string, filename string) (*extractSections, error) {
+	var textIndex = elfFile.FindSectionIndex(".text")
+
+	if name == "" {
+		if textIndex != -1 {
+			return &extractSections{".text", ".data", 0}, nil
+		}
+
+		var names []string = nil
+
+		for _, section := range elfFile.Sections {
+			if strings.HasPrefix(section.Name, ".text.") {
+				names = append(names, strings.TrimPrefix(section.Name, ".text."))
+			}
+		}
+
+		if len(names) > 0 {
+			return nil, errors.New(filename + " holds several microcodes, use -n to pick one of " + strings.Join(names, ", "))
+		}
+
+		return nil, errors.New(filename + " has no .text section")
+	}
+
+	if elfFile.FindSectionIndex(".text."+name) != -1 {
+		return &extractSections{".text." + name, ".data." + name, 0}, nil
+	}
+
+	if elfFile.FindSectionIndex(overlaySectionPrefix+name) != -1 {
+		loadAddress, ok := findOverlayLoadAddress(elfFile, elfSymbols, overlaySectionPrefix+name)
+
+		if !ok {
+			return nil, errors.New(filename + " has no _ovly_table entry for the overlay " + name)
+		}
+
+		return &extractSections{overlaySectionPrefix + name, "", loadAddress}, nil
+	}
+
+	// a microcode on its own only has its link name in the start and end symbols
+	if textIndex != -1 {
+		var text = &elfFile.Sections[textIndex]
+
+		if findLinkSymbols(elfSymbols, text.Address, uint32(len(text.Data)))[name+"TextStart"] {
+			return &extractSections{".text", ".data", 0}, nil
+		}
+	}
+
+	return nil, errors.New(filename + " has no microcode or overlay named " + name)
+}
+
+// symbols in an overlay are written at their IMEM address
+func writeDbgFile(filename string, elfFile *elf.ElfFile, elfSymbols []elf.ElfSymbol, sections *extractSections, textSize uint32) error {
+	var textIndex = elfFile.FindSectionIndex(sections.text)
+	var dataIndex = -1
+
+	if sections.data != "" {
+		dataIndex = elfFile.FindSectionIndex(sections.data)
+	}
+
+	var linkSymbols = findLinkSymbols(elfSymbols, elfFile.Sections[textIndex].Address, textSize)
+
+	var instructions SortSymbolsByValue = nil
+	var data SortSymbolsByValue = nil
+	var equates []SymbolDef = nil
+
+	for _, symbol := range elfSymbols {
+		if symbol.Name == "" || symbol.Type() == elf.STT_SECTION || symbol.Type() == elf.STT_FILE || linkSymbols[symbol.Name] {
+			continue
+		}
+
+		if symbol.SHIndex == elf.SHN_ABS {
+			// equates are only written by the microcode an overlay is loaded over
+			if sections.data == "" {
+				continue
+			}
+
+			equates = append(equates, SymbolDef{symbol.Name, symbol.Value, 0, SymbolEquate, "", 0})
+		} else if textIndex != -1 && int(symbol.SHIndex) == textIndex {
+			var kind = SymbolEntryPoint
+
+			if symbol.Binding() == elf.STB_LOCAL {
+				kind = SymbolLocalLabel
+			}
+
+			var value = symbol.Value - elfFile.Sections[textIndex].Address + sections.loadAddress
+			instructions = append(instructions, SymbolDef{symbol.Name, value, 0, kind, "", 0})
+		} else if dataIndex != -1 && int(symbol.SHIndex) == dataIndex {
+			var kind = SymbolData
+
+			if symbol.Binding() == elf.STB_LOCAL {
+				kind = SymbolLocalData
+			}
+
+			var value = symbol.Value - elfFile.Sections[dataIndex].Address
+			data = append(data, SymbolDef{symbol.Name, value, 0, kind, "", 0})
+		}
+	}
+
+	sort.Stable(instructions)
+	sort.Stable(data)
+
+	var output bytes.Buffer
+	var kindNames = map[SymbolKind]string{
+		SymbolEntryPoint: "I",
+		SymbolLocalLabel: "L",
+		SymbolData:       "D",
+		SymbolLocalData:  "d",
+		SymbolEquate:     "E",
+	}
+
+	for _, group := range [][]SymbolDef{instructions, data, equates} {
+		for _, symbol := range group {
+			fmt.Fprintf(&output, "%s %04X %s\n", quoteToken(symbol.Name), symbol.Value, kindNames[symbol.Kind])
+		}
+	}
+
+	return ioutil.WriteFile(filename, output.Bytes(), 0664)
+}
+
+// only the line tables of the units for the text section are written
+func writeSymFile(filename string, elfFile *elf.ElfFile, elfSymbols []elf.ElfSymbol, sections *extractSections) (bool, error) {
+	var debugLine = sectionData(elfFile, ".debug_line")
+	var debugLineStr = sectionData(elfFile, ".debug_line_str")
+	var byteOrder = elfFile.Header.ByteOrder()
+
+	if len(debugLine) == 0 {
+		return false, nil
+	}
+
+	units, err := dwarf.ParseDebugInfo(dwarf.DebugInfoSections{
+		Info:    sectionData(elfFile, ".debug_info"),
+		Abbrev:  sectionData(elfFile, ".debug_abbrev"),
+		Str:     sectionData(elfFile, ".debug_str"),
+		LineStr: debugLineStr,
+	}, byteOrder)
+
+	if err != nil {
+		return false, err
+	}
+
+	var textIndex = elfFile.FindSectionIndex(sections.text)
+	var textAddress = elfFile.Sections[textIndex].Address
+	var lines []dwarf.InstructionEntry = nil
+
+	for _, unit := range findSectionUnits(elfFile, units, elfSymbols, textIndex) {
+		stmtList, hasLines := unit.Number(dwarf.DW_AT_stmt_list)
+
+		if !hasLines {
+			continue
+		}
+
+		unitLines, err := dwarf.ParseDebugLinesAt(debugLine, debugLineStr, int(stmtList), byteOrder)
+
+		if err != nil {
+			return false, err
+		}
+
+		lines = append(lines, unitLines...)
+	}
+
+	if len(lines) == 0 {
+		return false, nil
+	}
+
+	var output bytes.Buffer
+
+	for _, line := range lines {
+		var address = line.Address() - int(textAddress) + int(sections.loadAddress)
+
+		fmt.Fprintf(&output, "line 0x%04x %s %d", address, quoteToken(line.Filename()), line.Line())
+
+		if line.Column() != 0 {
+			fmt.Fprintf(&output, " %d", line.Column())
+		}
+
+		output.WriteByte('\n')
+	}
+
+	return true, ioutil.WriteFile(filename, output.Bytes(), 0664)
+}
+
+// relocations in the data only come from a .relocs file
+func writeRelocsFile(filename string, elfFile *elf.ElfFile, symbols []elf.ElfSymbol, dataSection string) (bool, error) {
+	if dataSection == "" {
+		return false, nil
+	}
+
+	var relocations = sectionData(elfFile, ".rel"+dataSection)
+
+	if len(relocations) == 0 {
+		return false, nil
+	}
+
+	var output bytes.Buffer
+	var byteOrder = elfFile.Header.ByteOrder()
+
+	for offset := 0; offset+8 <= len(relocations); offset += 8 {
+		var address = byteOrder.Uint32(relocations[offset:])
+		var info = byteOrder.Uint32(relocations[offset+4:])
+		var symbolIndex = int(info >> 8)
+
+		if elf.RelocationType(info&0xFF) != elf.R_MIPS_32 || symbolIndex >= len(symbols) || symbols[symbolIndex].Type() == elf.STT_SECTION {
+			continue
+		}
+
+		fmt.Fprintf(&output, "0x%04X %s\n", address, quoteToken(symbols[symbolIndex].Name))
+	}
+
+	if output.Len() == 0 {
+		return false, nil
+	}
+
+	return true, ioutil.WriteFile(filename, output.Bytes(), 0664)
+}
+
+func extractMicrocode(args *extractArgs) error {
+	file, err := os.Open(args.input)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	elfFile, err := elf.ParseElf(file)
+
+	if err != nil {
+		return err
+	}
+
+	if elfFile.Header.ByteOrder() != binary.BigEndian {
+		return errors.New(args.input + " should be big endian")
+	}
+
+	elfSymbols, err := elfFile.ReadSymbols()
+
+	if err != nil {
+		return err
+	}
+
+	sections, err := findExtractSections(elfFile, elfSymbols, args.name, args.input)
+
+	if err != nil {
+		return err
+	}
+
+	textData, _, err := readSectionContents(elfFile, sections.text, args.input)
+
+	if err != nil {
+		return err
+	}
+
+	// overlays only replace IMEM so they get an empty .dat
+	var dataData []byte = nil
+
+	if sections.data != "" {
+		dataData, _, err = readSectionContents(elfFile, sections.data, args.input)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(args.output, textData, 0664)
+
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(args.output+".dat", dataData, 0664)
+
+	if err != nil {
+		return err
+	}
+
+	err = writeDbgFile(args.output+".dbg", elfFile, elfSymbols, sections, uint32(len(textData)))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = writeRelocsFile(args.output+".relocs", elfFile, elfSymbols, sections.data)
+
+	if err != nil {
+		return err
+	}
+
+	hasLines, err := writeSymFile(args.output+".sym", elfFile, elfSymbols, sections)
+
+	if err != nil {
+		return err
+	}
+
+	if !hasLines {
+		reportWarnings([]diagnostic{createDiagnostic(args.input, 0, "no line info for %s, %s.sym was not written", sections.text, args.output)})
+	}
+
+	return nil
+}
+
+func runExtract(args []string) error {
+	extract, err := parseExtractArgs(args)
+
+	if err != nil {
+		return err
+	}
+
+	return extractMicrocode(extract)
+}
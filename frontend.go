@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+type MicrocodeInput struct {
+	Text     []byte
+	Data     []byte
+	Lines    []dwarf.InstructionEntry
+	Symbols  *SymbolTable
+	Producer string
+	// already built debug sections to use instead of generating them
+	DebugSections []DebugSection
+	Overlays      []*Overlay
+	// pointers in DMEM to symbols outside of the microcode
+	External []ExternalReference
+}
+
+// which debug info Load reads along with the text and data
+type DebugContent int
+
+const (
+	DebugSymbols DebugContent = 1 << iota
+	// fails when the line info is missing
+	DebugLines
+	// reads the line info only when it is there
+	DebugLinesIfPresent
+)
+
+const DebugNone DebugContent = 0
+const DebugAll = DebugSymbols | DebugLines
+
+// whether the line info in filename should be read
+func (content DebugContent) readsLines(filename string) bool {
+	return content&DebugLines != 0 || content&DebugLinesIfPresent != 0 && fileExists(filename)
+}
+
+type InputFrontend interface {
+	Name() string
+	// checks the files next to input to see if they belong to this assembler
+	Detect(input string) bool
+	// textAddress is where in IMEM the code is loaded, addresses
+	// in the symbols and line info are moved to be relative to it
+	Load(input string, content DebugContent, textAddress uint32) (*MicrocodeInput, error)
+}
+
+const autoFrontend = "auto"
+
+var inputFrontends = []InputFrontend{
+	rspasmFrontend{},
+	armipsFrontend{},
+	bassFrontend{},
+	elfFrontend{},
+}
+
+func frontendNames() []string {
+	var result []string = nil
+
+	for _, frontend := range inputFrontends {
+		result = append(result, frontend.Name())
+	}
+
+	return result
+}
+
+// without any debug files an image and its .dat can still be loaded
+// as long as content doesn't need the symbols or lines
+func findFrontend(name string, input string, content DebugContent) (InputFrontend, error) {
+	if name == autoFrontend {
+		for _, frontend := range inputFrontends {
+			if frontend.Detect(input) {
+				return frontend, nil
+			}
+		}
+
+		if content&(DebugSymbols|DebugLines) == 0 && fileExists(input) && fileExists(input+".dat") {
+			return rspasmFrontend{}, nil
+		}
+
+		return nil, errors.New("Could not detect the assembler that produced " + input + ", use -i to pick one")
+	}
+
+	for _, frontend := range inputFrontends {
+		if frontend.Name() == name {
+			return frontend, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown input format '%s', expected %s or one of %s", name, autoFrontend, strings.Join(frontendNames(), ", "))
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+func readFirstToken(filename string) string {
+	data, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range splitLines(string(data)) {
+		parts, err := tokenizeLine(line)
+
+		if err == nil && len(parts) > 0 {
+			return parts[0]
+		}
+	}
+
+	return ""
+}
+
+func readTextAndData(input string) ([]byte, []byte, error) {
+	textData, err := ioutil.ReadFile(input)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataData, err := ioutil.ReadFile(input + ".dat")
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return textData, dataData, nil
+}
+
+const imemFlag = 0x1000
+const spMemoryMask = 0x1FFF
+
+// armips and bass assemble against the full SP memory map so IMEM
+// labels are at 0x04001000 and DMEM labels at 0x04000000
+func classifyRspAddress(address uint32) (uint32, bool, bool) {
+	if address&^spMemoryMask != 0 && address&^spMemoryMask != 0x04000000 {
+		return 0, false, false
+	}
+
+	return address & 0xFFF, address&imemFlag != 0, true
+}
+
+func createFrontendSymbol(name string, address uint32, filename string, lineNumber int) (SymbolDef, diagnostic, bool) {
+	offset, isText, ok := classifyRspAddress(address)
+
+	if !ok {
+		return SymbolDef{}, createDiagnostic(filename, lineNumber, "ignoring symbol '%s' at 0x%08X outside of SP memory", name, address), false
+	}
+
+	var result = SymbolDef{name, offset, 0, SymbolData, "", lineNumber}
+
+	if isText {
+		if isLocalLabelName(name) {
+			result.Kind = SymbolLocalLabel
+		} else {
+			result.Kind = SymbolEntryPoint
+		}
+	}
+
+	return result, diagnostic{}, true
+}
+
+func buildSymbolTable(symbols []SymbolDef, textAddress uint32, textSize int, dataSize int) *SymbolTable {
+	var instructionSymbols SortSymbolsByValue = nil
+	var dataSymbols SortSymbolsByValue = nil
+
+	for _, symbol := range symbols {
+		if isDataKind(symbol.Kind) {
+			dataSymbols = append(dataSymbols, symbol)
+		} else if symbol.Value >= textAddress {
+			// labels before textAddress belong to code loaded elsewhere
+			symbol.Value -= textAddress
+			instructionSymbols = append(instructionSymbols, symbol)
+		}
+	}
+
+	return &SymbolTable{
+		assignRanges(instructionSymbols, textSize),
+		assignRanges(dataSymbols, dataSize),
+		nil,
+	}
+}
+
+// keeps the lines inside the code loaded at textAddress
+func rebaseLines(lines []dwarf.InstructionEntry, textAddress uint32, textSize int) []dwarf.InstructionEntry {
+	if textAddress == 0 {
+		return lines
+	}
+
+	var result []dwarf.InstructionEntry = nil
+
+	for _, line := range lines {
+		var offset = line.Address() - int(textAddress)
+
+		if offset >= 0 && offset < textSize {
+			result = append(result, line.WithAddress(offset))
+		}
+	}
+
+	return result
+}
+
+// line info in the same address=value form as an armips .loadtable
+// such as 04001000=rsp/microcode.s:12
+func parseLineTable(filename string, input string) ([]dwarf.InstructionEntry, error) {
+	var result []dwarf.InstructionEntry = nil
+
+	for index, line := range splitLines(input) {
+		var lineNumber = index + 1
+		line = strings.TrimSpace(line)
+
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		var equals = strings.Index(line, "=")
+		var colon = strings.LastIndex(line, ":")
+
+		if equals == -1 || colon < equals {
+			return nil, createDiagnostic(filename, lineNumber, "expected address=file:line")
+		}
+
+		address, err := strconv.ParseUint(strings.TrimSpace(line[:equals]), 16, 32)
+
+		if err != nil {
+			return nil, createDiagnostic(filename, lineNumber, "invalid address '%s'", line[:equals])
+		}
+
+		sourceLine, err := strconv.ParseInt(strings.TrimSpace(line[colon+1:]), 10, 32)
+
+		if err != nil {
+			return nil, createDiagnostic(filename, lineNumber, "invalid line number '%s'", line[colon+1:])
+		}
+
+		offset, isText, ok := classifyRspAddress(uint32(address))
+
+		if !ok || !isText {
+			return nil, createDiagnostic(filename, lineNumber, "address 0x%08X is not in IMEM", address)
+		}
+
+		result = append(result, dwarf.CreateInstructionEntry(
+			int(offset),
+			strings.TrimSpace(line[equals+1:colon]),
+			int(sourceLine),
+			0,
+			true,
+			false,
+		))
+	}
+
+	return result, nil
+}
+
+func readLineTable(input string) ([]dwarf.InstructionEntry, error) {
+	var linesFilename = input + ".lines"
+
+	if !fileExists(linesFilename) {
+		reportWarnings([]diagnostic{createDiagnostic(linesFilename, 0, "not found, no line information will be written")})
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(linesFilename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLineTable(linesFilename, string(data))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// armips writes the text and data with .create, symbols with -sym2
+// and line info is read from input.lines
+type armipsFrontend struct{}
+
+func (frontend armipsFrontend) Name() string {
+	return "armips"
+}
+
+func isHexAddress(value string) bool {
+	if len(value) != 8 {
+		return false
+	}
+
+	_, err := strconv.ParseUint(value, 16, 32)
+
+	return err == nil
+}
+
+func (frontend armipsFrontend) Detect(input string) bool {
+	return isHexAddress(readFirstToken(input + ".sym"))
+}
+
+// each line is "<address> <name>[,<size>]", names starting
+// with .byt: .wrd: .dbl: or .asc: mark data directives
+func parseArmipsSymFile(filename string, input string, textAddress uint32, textSize int, dataSize int) (*SymbolTable, []diagnostic, error) {
+	var symbols []SymbolDef = nil
+	var warnings []diagnostic = nil
+
+	for index, line := range splitLines(input) {
+		var lineNumber = index + 1
+
+		parts, err := tokenizeLine(line)
+
+		if err != nil {
+			return nil, warnings, createDiagnostic(filename, lineNumber, "%s", err.Error())
+		}
+
+		if len(parts) == 0 {
+			continue
+		}
+
+		if len(parts) != 2 {
+			return nil, warnings, createDiagnostic(filename, lineNumber, "expected an address and a name")
+		}
+
+		address, err := strconv.ParseUint(parts[0], 16, 32)
+
+		if err != nil {
+			return nil, warnings, createDiagnostic(filename, lineNumber, "invalid address '%s'", parts[0])
+		}
+
+		var name = strings.Split(parts[1], ",")[0]
+
+		if (strings.HasPrefix(name, ".") && strings.Contains(name, ":")) || strings.Trim(name, "0123456789") == "" {
+			continue
+		}
+
+		symbol, warning, ok := createFrontendSymbol(name, uint32(address), filename, lineNumber)
+
+		if !ok {
+			warnings = append(warnings, warning)
+			continue
+		}
+
+		symbols = append(symbols, symbol)
+	}
+
+	return buildSymbolTable(symbols, textAddress, textSize, dataSize), warnings, nil
+}
+
+func (frontend armipsFrontend) Load(input string, content DebugContent, textAddress uint32) (*MicrocodeInput, error) {
+	textData, dataData, err := readTextAndData(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result = &MicrocodeInput{textData, dataData, nil, nil, frontend.Name(), nil, nil, nil}
+
+	if content&DebugSymbols != 0 {
+		var symFilename = input + ".sym"
+
+		symData, err := ioutil.ReadFile(symFilename)
+
+		if err != nil {
+			return nil, err
+		}
+
+		symbols, warnings, err := parseArmipsSymFile(symFilename, string(symData), textAddress, len(textData), len(dataData))
+
+		reportWarnings(warnings)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Symbols = symbols
+	}
+
+	if content.readsLines(input + ".lines") {
+		lines, err := readLineTable(input)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Lines = rebaseLines(lines, textAddress, len(textData))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// bass writes the text and data with output, symbols with -sym
+// and line info is read from input.lines
+type bassFrontend struct{}
+
+func (frontend bassFrontend) Name() string {
+	return "bass"
+}
+
+func (frontend bassFrontend) Detect(input string) bool {
+	var first = readFirstToken(input + ".sym")
+	return first == "[labels]" || strings.Contains(first, ":")
+}
+
+// lines are "<bank>:<address> <name>" under a [labels] header,
+// comments start with ; and other sections are skipped
+func parseBassSymFile(filename string, input string, textAddress uint32, textSize int, dataSize int) (*SymbolTable, []diagnostic, error) {
+	var symbols []SymbolDef = nil
+	var warnings []diagnostic = nil
+	var inLabels = true
+
+	for index, line := range splitLines(input) {
+		var lineNumber = index + 1
+
+		if comment := strings.Index(line, ";"); comment != -1 {
+			line = line[:comment]
+		}
+
+		parts, err := tokenizeLine(line)
+
+		if err != nil {
+			return nil, warnings, createDiagnostic(filename, lineNumber, "%s", err.Error())
+		}
+
+		if len(parts) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(parts[0], "[") {
+			inLabels = parts[0] == "[labels]"
+			continue
+		}
+
+		if !inLabels {
+			continue
+		}
+
+		if len(parts) != 2 {
+			return nil, warnings, createDiagnostic(filename, lineNumber, "expected an address and a name")
+		}
+
+		address, err := strconv.ParseUint(strings.Replace(parts[0], ":", "", -1), 16, 32)
+
+		if err != nil {
+			return nil, warnings, createDiagnostic(filename, lineNumber, "invalid address '%s'", parts[0])
+		}
+
+		symbol, warning, ok := createFrontendSymbol(parts[1], uint32(address), filename, lineNumber)
+
+		if !ok {
+			warnings = append(warnings, warning)
+			continue
+		}
+
+		symbols = append(symbols, symbol)
+	}
+
+	return buildSymbolTable(symbols, textAddress, textSize, dataSize), warnings, nil
+}
+
+func (frontend bassFrontend) Load(input string, content DebugContent, textAddress uint32) (*MicrocodeInput, error) {
+	textData, dataData, err := readTextAndData(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result = &MicrocodeInput{textData, dataData, nil, nil, frontend.Name(), nil, nil, nil}
+
+	if content&DebugSymbols != 0 {
+		var symFilename = input + ".sym"
+
+		symData, err := ioutil.ReadFile(symFilename)
+
+		if err != nil {
+			return nil, err
+		}
+
+		symbols, warnings, err := parseBassSymFile(symFilename, string(symData), textAddress, len(textData), len(dataData))
+
+		reportWarnings(warnings)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Symbols = symbols
+	}
+
+	if content.readsLines(input + ".lines") {
+		lines, err := readLineTable(input)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Lines = rebaseLines(lines, textAddress, len(textData))
+	}
+
+	return result, nil
+}
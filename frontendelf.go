@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+// debug sections copied from an input elf with their addresses
+// moved to be relative to the start of .text or .data
+type DebugSection struct {
+	Name        string
+	Data        []byte
+	EntrySize   uint32
+	Relocations *elf.RelocationBuilder
+}
+
+// a linked elf from mips64-elf-gcc such as the microcodes built by
+// libdragon, .text and .data are placed at their IMEM and DMEM addresses
+type elfFrontend struct{}
+
+func (frontend elfFrontend) Name() string {
+	return "elf"
+}
+
+func (frontend elfFrontend) Detect(input string) bool {
+	file, err := os.Open(input)
+
+	if err != nil {
+		return false
+	}
+
+	defer file.Close()
+
+	var magic = make([]byte, 4)
+	count, _ := file.Read(magic)
+
+	return count == 4 && bytes.Equal(magic, []byte{0x7F, 'E', 'L', 'F'})
+}
+
+type sectionRange struct {
+	name    string
+	index   int
+	address uint32
+	size    uint32
+}
+
+func (section *sectionRange) contains(address uint64) bool {
+	return section.index != -1 && address >= uint64(section.address) && address < uint64(section.address)+uint64(section.size)
+}
+
+// an address just past the end of a section, such as a high_pc or
+// the end of a range, only belongs to it if no section starts there
+func findOwningSection(ranges []sectionRange, address uint64) *sectionRange {
+	for index := range ranges {
+		if ranges[index].contains(address) {
+			return &ranges[index]
+		}
+	}
+
+	for index := range ranges {
+		var section = &ranges[index]
+
+		if section.index != -1 && address == uint64(section.address)+uint64(section.size) {
+			return section
+		}
+	}
+
+	return nil
+}
+
+func findSectionRange(elfFile *elf.ElfFile, name string) sectionRange {
+	var index = elfFile.FindSectionIndex(name)
+
+	if index == -1 {
+		return sectionRange{name, -1, 0, 0}
+	}
+
+	var section = &elfFile.Sections[index]
+
+	return sectionRange{name, index, section.Address, uint32(len(section.Data))}
+}
+
+func sectionData(elfFile *elf.ElfFile, name string) []byte {
+	var index = elfFile.FindSectionIndex(name)
+
+	if index == -1 {
+		return nil
+	}
+
+	return elfFile.Sections[index].Data
+}
+
+func rebaseAddresses(data []byte, fields []dwarf.AddressField, ranges []sectionRange, byteOrder binary.ByteOrder) *elf.RelocationBuilder {
+	var relocations = elf.NewRelocationBuilder()
+
+	for _, field := range fields {
+		var section = findOwningSection(ranges, field.Value)
+
+		if section == nil {
+			continue
+		}
+
+		var offset = field.Value - uint64(section.address)
+
+		if field.Size == 8 {
+			byteOrder.PutUint64(data[field.Offset:], offset)
+			relocations.AddEntry(uint32(field.Offset), section.name, elf.R_MIPS_64)
+		} else if field.Size == 4 {
+			byteOrder.PutUint32(data[field.Offset:], uint32(offset))
+			relocations.AddEntry(uint32(field.Offset), section.name, elf.R_MIPS_32)
+		}
+	}
+
+	return relocations
+}
+
+var passThroughDebugSections = []string{
+	".debug_abbrev",
+	".debug_str",
+	".debug_line_str",
+}
+
+func rebaseDebugSections(elfFile *elf.ElfFile, ranges []sectionRange, warnings *[]diagnostic, filename string) ([]DebugSection, error) {
+	var byteOrder = elfFile.Header.ByteOrder()
+	var result []DebugSection = nil
+	var handled = make(map[string]bool)
+
+	var copySection = func(name string) []byte {
+		handled[name] = true
+		return append([]byte(nil), sectionData(elfFile, name)...)
+	}
+
+	var debugInfo = copySection(".debug_info")
+	var debugLine = copySection(".debug_line")
+	var debugAranges = copySection(".debug_aranges")
+	var debugRanges = copySection(".debug_ranges")
+	var debugLoc = copySection(".debug_loc")
+	// the dwarf 5 forms that index into these keep working since
+	// the sections are copied whole and only the addresses change
+	var debugAddr = copySection(".debug_addr")
+	var debugRngLists = copySection(".debug_rnglists")
+	var debugLocLists = copySection(".debug_loclists")
+
+	var infoSections = dwarf.DebugInfoSections{
+		Info:    debugInfo,
+		Abbrev:  sectionData(elfFile, ".debug_abbrev"),
+		Str:     sectionData(elfFile, ".debug_str"),
+		LineStr: sectionData(elfFile, ".debug_line_str"),
+	}
+
+	infoAddresses, err := dwarf.FindInfoAddresses(infoSections, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lineAddresses, err := dwarf.FindLineAddresses(debugLine, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	arangeAddresses, err := dwarf.FindArangesAddresses(debugAranges, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rangeAddresses, err := dwarf.FindRangesAddresses(debugRanges, 4, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	locAddresses, err := dwarf.FindLocAddresses(debugLoc, 4, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addrAddresses, err := dwarf.FindAddrAddresses(debugAddr, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rngListAddresses, err := dwarf.FindRngListsAddresses(debugRngLists, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	locListAddresses, err := dwarf.FindLocListsAddresses(debugLocLists, byteOrder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rebased = []struct {
+		name   string
+		data   []byte
+		fields []dwarf.AddressField
+	}{
+		{".debug_info", debugInfo, infoAddresses},
+		{".debug_line", debugLine, lineAddresses},
+		{".debug_aranges", debugAranges, arangeAddresses},
+		{".debug_ranges", debugRanges, rangeAddresses},
+		{".debug_loc", debugLoc, locAddresses},
+		{".debug_addr", debugAddr, addrAddresses},
+		{".debug_rnglists", debugRngLists, rngListAddresses},
+		{".debug_loclists", debugLocLists, locListAddresses},
+	}
+
+	for _, section := range rebased {
+		if len(section.data) == 0 {
+			continue
+		}
+
+		result = append(result, DebugSection{
+			section.name,
+			section.data,
+			0,
+			rebaseAddresses(section.data, section.fields, ranges, byteOrder),
+		})
+	}
+
+	for _, name := range passThroughDebugSections {
+		var data = copySection(name)
+
+		if len(data) > 0 {
+			result = append(result, DebugSection{name, data, 1, nil})
+		}
+	}
+
+	for _, section := range elfFile.Sections {
+		if strings.HasPrefix(section.Name, ".debug_") && !handled[section.Name] {
+			*warnings = append(*warnings, createDiagnostic(filename, 0, "dropping %s, its addresses cannot be rebased", section.Name))
+		}
+	}
+
+	return result, nil
+}
+
+func convertElfSymbols(elfFile *elf.ElfFile, text sectionRange, data sectionRange) (*SymbolTable, error) {
+	elfSymbols, err := elfFile.ReadSymbols()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var symbols []SymbolDef = nil
+	var equates []SymbolDef = nil
+
+	for _, symbol := range elfSymbols {
+		if symbol.Name == "" || symbol.Type() == elf.STT_SECTION || symbol.Type() == elf.STT_FILE {
+			continue
+		}
+
+		var isLocal = symbol.Binding() == elf.STB_LOCAL
+
+		if symbol.SHIndex == elf.SHN_ABS {
+			equates = append(equates, SymbolDef{symbol.Name, symbol.Value, 0, SymbolEquate, "", 0})
+		} else if int(symbol.SHIndex) == text.index {
+			var kind = SymbolEntryPoint
+
+			if isLocal && symbol.Type() != elf.STT_FUNC {
+				kind = SymbolLocalLabel
+			}
+
+			symbols = append(symbols, SymbolDef{symbol.Name, symbol.Value - text.address, 0, kind, "", 0})
+		} else if int(symbol.SHIndex) == data.index {
+			var kind = SymbolData
+
+			if isLocal {
+				kind = SymbolLocalData
+			}
+
+			symbols = append(symbols, SymbolDef{symbol.Name, symbol.Value - data.address, 0, kind, "", 0})
+		}
+	}
+
+	var result = buildSymbolTable(symbols, 0, int(text.size), int(data.size))
+	result.Equates = equates
+
+	return result, nil
+}
+
+// textAddress isn't needed since the elf already says where .text was linked
+func (frontend elfFrontend) Load(input string, content DebugContent, textAddress uint32) (*MicrocodeInput, error) {
+	file, err := os.Open(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	elfFile, err := elf.ParseElf(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if elfFile.Header.Machine() != elf.EM_MIPS {
+		return nil, errors.New(input + " is not a MIPS elf file")
+	}
+
+	if elfFile.Header.Type() != elf.ET_EXEC {
+		return nil, errors.New(input + " should be linked with .text and .data at their IMEM and DMEM addresses")
+	}
+
+	if elfFile.Header.ByteOrder() != binary.BigEndian {
+		return nil, errors.New(input + " should be big endian")
+	}
+
+	var text = findSectionRange(elfFile, ".text")
+	var data = findSectionRange(elfFile, ".data")
+
+	if text.index == -1 {
+		return nil, errors.New(input + " has no .text section")
+	}
+
+	var result = &MicrocodeInput{
+		sectionData(elfFile, ".text"),
+		sectionData(elfFile, ".data"),
+		nil,
+		nil,
+		frontend.Name(),
+		nil,
+		nil,
+		nil,
+	}
+
+	// symbols and line info come from the same file so they are read together
+	if content == DebugNone {
+		return result, nil
+	}
+
+	var warnings []diagnostic = nil
+
+	result.Symbols, err = convertElfSymbols(elfFile, text, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := dwarf.ParseDebugLines(sectionData(elfFile, ".debug_line"), sectionData(elfFile, ".debug_line_str"), binary.BigEndian)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if text.contains(uint64(line.Address())) {
+			result.Lines = append(result.Lines, line.WithAddress(line.Address()-int(text.address)))
+		}
+	}
+
+	result.DebugSections, err = rebaseDebugSections(elfFile, []sectionRange{text, data}, &warnings, input)
+
+	reportWarnings(warnings)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
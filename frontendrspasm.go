@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+// rspasm writes input, input.dat, input.sym and input.dbg
+type rspasmFrontend struct{}
+
+func (frontend rspasmFrontend) Name() string {
+	return "rspasm"
+}
+
+func (frontend rspasmFrontend) Detect(input string) bool {
+	return fileExists(input+".dbg") || readFirstToken(input+".sym") == "line"
+}
+
+func (frontend rspasmFrontend) Load(input string, content DebugContent, textAddress uint32) (*MicrocodeInput, error) {
+	textData, dataData, err := readTextAndData(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result = &MicrocodeInput{textData, dataData, nil, nil, frontend.Name(), nil, nil, nil}
+
+	// the line info is in the .sym and the symbols in the .dbg
+	if content.readsLines(input + ".sym") {
+		lines, err := readSymFile(input)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Lines = rebaseLines(lines, textAddress, len(textData))
+	}
+
+	if content&DebugSymbols != 0 {
+		result.Symbols, err = readDbgFile(input, textAddress, len(textData), len(dataData))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func readSymFile(textFilename string) ([]dwarf.InstructionEntry, error) {
+	var symFilename = textFilename + ".sym"
+
+	symFile, err := os.Open(symFilename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer symFile.Close()
+
+	symData, err := ioutil.ReadAll(symFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	instructions, warnings, err := parseSymFile(symFilename, string(symData))
+
+	reportWarnings(warnings)
+
+	return instructions, err
+}
+
+func readDbgFile(textFilename string, textAddress uint32, textSize int, dataSize int) (*SymbolTable, error) {
+	var dbgFilename = textFilename + ".dbg"
+
+	dbgFile, err := os.Open(dbgFilename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer dbgFile.Close()
+
+	dbgData, err := ioutil.ReadAll(dbgFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, warnings, err := parseDbgFile(dbgFilename, string(dbgData), textAddress, textSize, dataSize)
+
+	reportWarnings(warnings)
+
+	return symbols, err
+}
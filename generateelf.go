@@ -2,134 +2,237 @@ package main
 
 import (
 	"encoding/binary"
-	"io/ioutil"
-	"os"
+	"errors"
 
 	"github.com/lambertjamesd/rsp2dwarf/dwarf"
 	"github.com/lambertjamesd/rsp2dwarf/elf"
 )
 
-func appendDebugSymbols(elfFile *elf.ElfFile, textFilename string, compDir string, textSectionLength int) error {
-	symFile, err := os.Open(textFilename + ".sym")
+func buildDbgSymbols(symbols *SymbolTable, textSection uint16, dataSection uint16) []elf.ElfSymbol {
+	var result []elf.ElfSymbol = nil
 
-	if err != nil {
-		return err
+	for _, iSymbol := range symbols.Instructions {
+		if iSymbol.Kind == SymbolLocalLabel {
+			result = append(result, elf.BuildSymbol(iSymbol.Name, iSymbol.Value, 0, elf.STB_LOCAL, elf.STT_NOTYPE, 0, textSection))
+		} else {
+			result = append(result, elf.BuildSymbol(iSymbol.Name, iSymbol.Value, iSymbol.Size, elf.STB_GLOBAL, elf.STT_FUNC, 0, textSection))
+		}
 	}
 
-	defer symFile.Close()
+	for _, dSymbol := range symbols.Data {
+		if dSymbol.Kind == SymbolLocalData {
+			result = append(result, elf.BuildSymbol(dSymbol.Name, dSymbol.Value, dSymbol.Size, elf.STB_LOCAL, elf.STT_OBJECT, 0, dataSection))
+		} else {
+			result = append(result, elf.BuildSymbol(dSymbol.Name, dSymbol.Value, dSymbol.Size, elf.STB_GLOBAL, elf.STT_OBJECT, 0, dataSection))
+		}
+	}
 
-	symData, err := ioutil.ReadAll(symFile)
+	// equates are kept local so the same constant in two
+	// microcodes doesn't cause a multiple definition error
+	for _, equate := range symbols.Equates {
+		result = append(result, elf.BuildSymbol(equate.Name, equate.Value, 0, elf.STB_LOCAL, elf.STT_NOTYPE, 0, elf.SHN_ABS))
+	}
 
-	instructions, err := parseSymFile(string(symData))
+	return result
+}
 
-	if err != nil {
-		return err
-	}
+// a microcode along with the sections it is placed in
+type Microcode struct {
+	Name        string
+	Input       *MicrocodeInput
+	TextSection string
+	DataSection string
+	// layout of the task struct written for the microcode, if any
+	TaskFormat string
+	// IMEM offset the text runs at, such as 0x80 after rspboot
+	LoadAddress uint32
+}
 
-	var symbolMapping = make(map[string]uint32)
+// a microcode on its own keeps the plain .text and .data section
+// names, when sharing an object each one gets its own sections
+func placeMicrocodes(names []string, inputs []*MicrocodeInput, taskFormat string, loadAddress uint32) []*Microcode {
+	var result []*Microcode = nil
 
-	// only symbols actually used
-	symbolMapping[".text"] = 1
-	symbolMapping[".data"] = 2
+	for index, input := range inputs {
+		var microcode = &Microcode{names[index], input, ".text", ".data", taskFormat, loadAddress}
 
-	debugLineData, debugLineRef := dwarf.GenerateDebugLines(instructions, binary.BigEndian)
+		if len(inputs) > 1 {
+			microcode.TextSection = ".text." + names[index]
+			microcode.DataSection = ".data." + names[index]
+		}
 
-	elfFile.Sections = append(elfFile.Sections, elf.BuildElfSection(
-		".debug_line",
-		elf.SHT_MIPS_DWARF,
-		0,
-		0,
-		0,
-		0,
-		1,
-		0,
-		debugLineData,
-	))
+		result = append(result, microcode)
+	}
 
-	elfFile.Sections = append(elfFile.Sections, debugLineRef.ToElfSection(".debug_line", symbolMapping, binary.BigEndian))
+	return result
+}
 
-	arangesData, arangesLineRef := dwarf.GenerateAranges(textSectionLength, binary.BigEndian)
+func appendDebugSymbols(elfFile *elf.ElfFile, microcodes []*Microcode, compDir string, profile *toolchainProfile, symbolMapping map[string]uint32) error {
+	var units = buildDebugUnits(microcodes)
 
-	elfFile.Sections = append(elfFile.Sections, elf.BuildElfSection(
-		".debug_aranges",
-		elf.SHT_MIPS_DWARF,
-		0,
-		0,
-		0,
-		0,
-		1,
-		0,
-		arangesData,
-	))
+	if len(units) == 0 {
+		return nil
+	}
 
-	elfFile.Sections = append(elfFile.Sections, arangesLineRef.ToElfSection(".debug_aranges", symbolMapping, binary.BigEndian))
+	var debugLineData []byte = nil
+	var debugLineRef = elf.NewRelocationBuilder()
+	var attributes []*dwarf.AbbrevTreeNode = nil
+
+	for _, unit := range units {
+		lineData, lineRef := dwarf.GenerateDebugLines(unit.lines, unit.section, profile.dwarfVersion, binary.BigEndian)
+		var subprograms = buildSubprograms(unit.calls, unit.section)
+		variables, variablesByName := buildDataVariables(unit.data, unit.dataSection, profile.dwarfVersion)
+		importDataVariables(subprograms, unit.calls.Routines, unit.references, variablesByName)
 
-	var attributes = []*dwarf.AbbrevTreeNode{
-		{
+		attributes = append(attributes, &dwarf.AbbrevTreeNode{
 			Tag: dwarf.DW_TAG_compile_unit,
 			Attributes: []dwarf.AbbrevAttr{
-				dwarf.CreateConstantAttr(dwarf.DW_AT_stmt_list, 0, 4),
-				dwarf.CreateAddrAttr(dwarf.DW_AT_low_pc, 0),
-				dwarf.CreateAddrAttr(dwarf.DW_AT_high_pc, int64(textSectionLength)),
-				dwarf.CreateStringAttr(dwarf.DW_AT_name, instructions[0].Filename(), false),
+				dwarf.CreateSectionOffsetAttr(dwarf.DW_AT_stmt_list, int64(len(debugLineData)), profile.dwarfVersion),
+				dwarf.CreateSectionAddrAttr(dwarf.DW_AT_low_pc, unit.section, 0),
+				dwarf.CreateSectionAddrAttr(dwarf.DW_AT_high_pc, unit.section, int64(unit.length)),
+				dwarf.CreateStringAttr(dwarf.DW_AT_name, unit.lines[0].Filename(), false),
 				dwarf.CreateStringAttr(dwarf.DW_AT_comp_dir, compDir, false),
-				dwarf.CreateStringAttr(dwarf.DW_AT_producer, "rspasm", false),
+				dwarf.CreateStringAttr(dwarf.DW_AT_producer, unit.producer, false),
 				dwarf.CreateConstantAttr(dwarf.DW_AT_language, dwarf.DW_LANG_Mips_Assembler, 2),
 			},
-			Children: nil,
-		},
+			Children: append(subprograms, variables...),
+		})
+
+		debugLineRef.Append(lineRef, uint32(len(debugLineData)))
+		debugLineData = append(debugLineData, lineData...)
 	}
 
-	var infoSections = dwarf.GenerateInfoAndAbbrev(attributes, binary.BigEndian)
+	elfFile.Sections = append(elfFile.Sections, profile.debugSection(".debug_line", 0, debugLineData))
 
-	elfFile.Sections = append(elfFile.Sections, elf.BuildElfSection(
-		".debug_info",
-		elf.SHT_MIPS_DWARF,
-		0,
-		0,
-		0,
-		0,
-		1,
-		0,
-		infoSections.Info,
-	))
+	elfFile.Sections = append(elfFile.Sections, debugLineRef.ToElfSection(".debug_line", symbolMapping, binary.BigEndian))
+
+	var infoSections = dwarf.GenerateInfoAndAbbrev(attributes, profile.dwarfVersion, binary.BigEndian)
+
+	var ranges []dwarf.AddressRange = nil
+
+	for index, unit := range units {
+		ranges = append(ranges, dwarf.AddressRange{Section: unit.section, Length: unit.length, InfoOffset: infoSections.UnitOffsets[index]})
+	}
+
+	arangesData, arangesLineRef := dwarf.GenerateAranges(ranges, binary.BigEndian)
+
+	elfFile.Sections = append(elfFile.Sections, profile.debugSection(".debug_aranges", 0, arangesData))
+
+	elfFile.Sections = append(elfFile.Sections, arangesLineRef.ToElfSection(".debug_aranges", symbolMapping, binary.BigEndian))
+
+	elfFile.Sections = append(elfFile.Sections, profile.debugSection(".debug_info", 0, infoSections.Info))
 
 	elfFile.Sections = append(elfFile.Sections, infoSections.RelInfo.ToElfSection(".debug_info", symbolMapping, binary.BigEndian))
 
-	elfFile.Sections = append(elfFile.Sections, elf.BuildElfSection(
-		".debug_abbrev",
-		elf.SHT_MIPS_DWARF,
-		0,
-		0,
-		0,
-		0,
-		1,
-		0,
-		infoSections.Abbrev,
-	))
+	elfFile.Sections = append(elfFile.Sections, profile.debugSection(".debug_abbrev", 0, infoSections.Abbrev))
+
+	elfFile.Sections = append(elfFile.Sections, profile.debugSection(".debug_str", 1, infoSections.DebugStr))
+
+	return nil
+}
 
-	elfFile.Sections = append(elfFile.Sections, elf.BuildElfSection(
-		".debug_str",
-		elf.SHT_MIPS_DWARF,
+func appendInputDebugSections(elfFile *elf.ElfFile, input *MicrocodeInput, profile *toolchainProfile, symbolMapping map[string]uint32) {
+	for _, section := range input.DebugSections {
+		elfFile.Sections = append(elfFile.Sections, profile.debugSection(section.Name, section.EntrySize, section.Data))
+
+		if section.Relocations != nil {
+			elfFile.Sections = append(elfFile.Sections, section.Relocations.ToElfSection(section.Name, symbolMapping, binary.BigEndian))
+		}
+	}
+}
+
+func buildContentSection(name string, flags elf.SectionHeaderFlags, data []byte) elf.ElfSection {
+	return elf.BuildElfSection(
+		name,
+		elf.SHT_PROGBITS,
+		flags,
 		0,
 		0,
 		0,
+		16,
 		0,
-		1,
-		1,
-		infoSections.DebugStr,
-	))
+		data,
+	)
+}
 
-	return nil
+// every section before the first non content section gets a section
+// symbol with the same index so relocations can refer to it by name
+func sectionSymbolMapping(elfFile *elf.ElfFile) (map[string]uint32, []elf.ElfSymbol) {
+	var mapping = make(map[string]uint32)
+	var symbols = []elf.ElfSymbol{
+		elf.BuildSymbol("", 0, 0, elf.STB_LOCAL, elf.STT_NOTYPE, 0, 0),
+	}
+
+	for index := 1; index < len(elfFile.Sections) && isContentSection(elfFile.Sections[index].Name); index++ {
+		var name = elfFile.Sections[index].Name
+		mapping[name] = uint32(index)
+		symbols = append(symbols, elf.BuildSymbol(name, 0, 0, elf.STB_LOCAL, elf.STT_SECTION, 0, uint16(index)))
+	}
+
+	return mapping, symbols
+}
+
+// the same label in two microcodes sharing an object
+// would fail to link so only the first one stays global
+func localizeDuplicateSymbols(microcode *Microcode, symbols []elf.ElfSymbol, defined map[string]string) []elf.ElfSymbol {
+	var warnings []diagnostic = nil
+
+	for index, symbol := range symbols {
+		if symbol.Binding() == elf.STB_LOCAL {
+			continue
+		}
+
+		previous, exists := defined[symbol.Name]
+
+		if exists {
+			warnings = append(warnings, createDiagnostic(microcode.Name, 0, "symbol '%s' is also defined by %s, making it local", symbol.Name, previous))
+			symbols[index] = elf.BuildSymbol(symbol.Name, symbol.Value, symbol.Size, elf.STB_LOCAL, symbol.Type(), symbol.Other, symbol.SHIndex)
+		} else {
+			defined[symbol.Name] = microcode.Name
+		}
+	}
+
+	reportWarnings(warnings)
+
+	return symbols
 }
 
-func buildElf(textFilename string, linkName string, compDir string, includeDebug bool) (*elf.ElfFile, error) {
+func buildMicrocodeSymbols(microcode *Microcode, symbolMapping map[string]uint32, includeDebug bool) []elf.ElfSymbol {
+	var textSection = uint16(symbolMapping[microcode.TextSection])
+	var dataSection = uint16(symbolMapping[microcode.DataSection])
+	var textLength = uint32(len(microcode.Input.Text))
+	var dataLength = uint32(len(microcode.Input.Data))
+
+	var result = []elf.ElfSymbol{
+		elf.BuildSymbol(microcode.Name+"TextStart", 0, textLength, elf.STB_GLOBAL, elf.STT_FUNC, 0, textSection),
+		elf.BuildSymbol(microcode.Name+"TextEnd", textLength, 0, elf.STB_GLOBAL, elf.STT_FUNC, 0, textSection),
+		elf.BuildSymbol(microcode.Name+"DataStart", 0, dataLength, elf.STB_GLOBAL, elf.STT_OBJECT, 0, dataSection),
+		elf.BuildSymbol(microcode.Name+"DataEnd", dataLength, 0, elf.STB_GLOBAL, elf.STT_OBJECT, 0, dataSection),
+	}
+
+	if includeDebug && microcode.Input.Symbols != nil {
+		result = append(result, buildDbgSymbols(microcode.Input.Symbols, textSection, dataSection)...)
+	}
+
+	for _, overlay := range microcode.Input.Overlays {
+		result = append(result, buildOverlaySymbols(overlay, uint16(symbolMapping[overlay.SectionName()]), includeDebug)...)
+	}
+
+	if microcode.TaskFormat != taskFormatNone {
+		var taskSection = uint16(symbolMapping[microcode.TaskSection()])
+		result = append(result, elf.BuildSymbol(microcode.TaskName(), 0, taskSize(microcode.TaskFormat), elf.STB_GLOBAL, elf.STT_OBJECT, 0, taskSection))
+	}
+
+	return result
+}
+
+func buildElf(microcodes []*Microcode, compDir string, includeDebug bool, debugFormat string, profile *toolchainProfile) (*elf.ElfFile, error) {
 	var result = &elf.ElfFile{
 		Header: elf.BuildElfHeader(
 			elf.ET_REL,
 			elf.EM_MIPS,
 			0,
-			0x20000101,
+			profile.flags,
 		),
 		Sections: nil,
 	}
@@ -146,96 +249,89 @@ func buildElf(textFilename string, linkName string, compDir string, includeDebug
 		nil,
 	))
 
-	textFile, err := os.Open(textFilename)
-
-	if err != nil {
-		return nil, err
-	}
+	var overlays []*Overlay = nil
+	var taskRelocations = make(map[string]*elf.RelocationBuilder)
 
-	defer textFile.Close()
+	for _, microcode := range microcodes {
+		result.Sections = append(result.Sections, buildContentSection(microcode.TextSection, elf.SHF_ALLOC|elf.SHF_EXECINSTR, microcode.Input.Text))
+		result.Sections = append(result.Sections, buildContentSection(microcode.DataSection, elf.SHF_WRITE|elf.SHF_ALLOC, microcode.Input.Data))
 
-	textData, err := ioutil.ReadAll(textFile)
+		for _, overlay := range microcode.Input.Overlays {
+			result.Sections = append(result.Sections, buildContentSection(overlay.SectionName(), elf.SHF_ALLOC|elf.SHF_EXECINSTR, overlay.Input.Text))
+			overlays = append(overlays, overlay)
+		}
 
-	if err != nil {
-		return nil, err
+		if microcode.TaskFormat != taskFormatNone {
+			data, relocations := buildTask(microcode)
+			result.Sections = append(result.Sections, buildTaskSection(microcode.TaskSection(), data))
+			taskRelocations[microcode.TaskSection()] = relocations
+		}
 	}
 
-	result.Sections = append(result.Sections, elf.BuildElfSection(
-		".text",
-		elf.SHT_PROGBITS,
-		elf.SHF_ALLOC|elf.SHF_EXECINSTR,
-		0,
-		0,
-		0,
-		16,
-		0,
-		textData,
-	))
+	symbolMapping, sectionSymbols := sectionSymbolMapping(result)
 
-	dataFile, err := os.Open(textFilename + ".dat")
+	for _, microcode := range microcodes {
+		var relocations, ok = taskRelocations[microcode.TaskSection()]
 
-	if err != nil {
-		return nil, err
+		if ok {
+			result.Sections = append(result.Sections, relocations.ToElfSection(microcode.TaskSection(), symbolMapping, binary.BigEndian))
+		}
 	}
 
-	defer dataFile.Close()
+	var overlayTableSection = uint16(len(result.Sections))
 
-	dataData, err := ioutil.ReadAll(dataFile)
-
-	if err != nil {
-		return nil, err
+	if len(overlays) > 0 {
+		result.Sections = append(result.Sections, buildOverlayTable(overlays))
 	}
 
-	result.Sections = append(result.Sections, elf.BuildElfSection(
-		".data",
-		elf.SHT_PROGBITS,
-		elf.SHF_WRITE|elf.SHF_ALLOC,
-		0,
-		0,
-		0,
-		16,
-		0,
-		dataData,
-	))
+	profile.appendMipsSections(result, binary.BigEndian)
 
 	if includeDebug {
-		err = appendDebugSymbols(result, textFilename, compDir, len(textData))
+		var err error
+		var first = microcodes[0]
 
-		if err != nil {
-			return nil, err
-		}
-	}
+		if debugFormat == debugFormatMDebug {
+			if len(microcodes) > 1 {
+				return nil, errors.New("Only one microcode per object is supported with " + debugFormatMDebug + " debug info")
+			}
 
-	result.AddSymbols([]elf.ElfSymbol{
-		elf.BuildSymbol("", 0, 0, elf.STB_LOCAL, elf.STT_NOTYPE, 0, 0),
-		elf.BuildSymbol(".text", 0, 0, elf.STB_LOCAL, elf.STT_SECTION, 0, 1),
-		elf.BuildSymbol(".data", 0, 0, elf.STB_LOCAL, elf.STT_SECTION, 0, 1),
-		elf.BuildSymbol(linkName+"TextStart", 0, uint32(len(textData)), elf.STB_GLOBAL, elf.STT_FUNC, 0, 1),
-		elf.BuildSymbol(linkName+"TextEnd", uint32(len(textData)), 0, elf.STB_GLOBAL, elf.STT_FUNC, 0, 1),
-		elf.BuildSymbol(linkName+"DataStart", 0, uint32(len(dataData)), elf.STB_GLOBAL, elf.STT_OBJECT, 0, 2),
-		elf.BuildSymbol(linkName+"DataEnd", uint32(len(dataData)), 0, elf.STB_GLOBAL, elf.STT_OBJECT, 0, 2),
-	}, binary.BigEndian)
+			var symbols = first.Input.Symbols
 
-	if includeDebug {
-		dbgFile, err := os.Open(textFilename + ".dbg")
+			if symbols == nil {
+				symbols = &SymbolTable{}
+			}
+
+			err = appendMDebugSymbols(result, first.Input, first.Name, symbols)
+		} else if len(microcodes) == 1 && len(first.Input.DebugSections) > 0 && len(overlays) == 0 {
+			appendInputDebugSections(result, first.Input, profile, symbolMapping)
+		} else {
+			err = appendDebugSymbols(result, microcodes, compDir, profile, symbolMapping)
+		}
 
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		defer dbgFile.Close()
+	result.AddSymbols(sectionSymbols, binary.BigEndian)
 
-		dbgData, err := ioutil.ReadAll(dbgFile)
+	var defined = make(map[string]string)
 
-		iSymbols, dSymbols := parseDbgFile(string(dbgData), len(textData), len(dataData))
+	for _, microcode := range microcodes {
+		var symbols = buildMicrocodeSymbols(microcode, symbolMapping, includeDebug)
+		result.AddSymbols(localizeDuplicateSymbols(microcode, symbols, defined), binary.BigEndian)
+	}
 
-		for _, iSymbol := range iSymbols {
-			result.AddSymbol(elf.BuildSymbol(iSymbol.Name, iSymbol.Value, iSymbol.Size, elf.STB_GLOBAL, elf.STT_FUNC, 0, 1))
-		}
+	if len(overlays) > 0 {
+		result.AddSymbols(buildOverlayTableSymbols(len(overlays), overlayTableSection), binary.BigEndian)
+		result.AddSymbols(buildOverlayLoadSymbols(overlays), binary.BigEndian)
+	}
 
-		for _, dSymbol := range dSymbols {
-			result.AddSymbol(elf.BuildSymbol(dSymbol.Name, dSymbol.Value, dSymbol.Size, elf.STB_GLOBAL, elf.STT_OBJECT, 0, 2))
-		}
+	result.AddSymbols(buildUndefinedSymbols(result, microcodes), binary.BigEndian)
+	appendExternalRelocations(result, microcodes)
+
+	if len(overlays) > 0 {
+		appendOverlayTableRelocations(result, overlays)
 	}
 
 	return result, nil
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+	"github.com/lambertjamesd/rsp2dwarf/mdebug"
+)
+
+const debugFormatDwarf = "dwarf"
+const debugFormatMDebug = "mdebug"
+
+func buildProcedures(linkName string, textSectionLength int, entryPoints []SymbolDef) []mdebug.Procedure {
+	var result []mdebug.Procedure = nil
+	var firstAddress = uint32(textSectionLength)
+
+	for _, iSymbol := range entryPoints {
+		result = append(result, mdebug.Procedure{
+			Name:     iSymbol.Name,
+			Address:  iSymbol.Value,
+			Size:     iSymbol.Size,
+			External: true,
+		})
+
+		if iSymbol.Value < firstAddress {
+			firstAddress = iSymbol.Value
+		}
+	}
+
+	// cover any code before the first label so every
+	// instruction has line information
+	if firstAddress > 0 {
+		result = append(result, mdebug.Procedure{
+			Name:     linkName + "TextStart",
+			Address:  0,
+			Size:     firstAddress,
+			External: false,
+		})
+	}
+
+	return result
+}
+
+func appendMDebugSymbols(elfFile *elf.ElfFile, input *MicrocodeInput, linkName string, symbols *SymbolTable) error {
+	var data []mdebug.DataSymbol = nil
+
+	for _, dSymbol := range symbols.Data {
+		if dSymbol.AliasOf != "" {
+			continue
+		}
+
+		data = append(data, mdebug.DataSymbol{
+			Name:    dSymbol.Name,
+			Address: dSymbol.Value,
+			Size:    dSymbol.Size,
+		})
+	}
+
+	elfFile.Sections = append(elfFile.Sections, elf.BuildElfSection(
+		".mdebug",
+		elf.SHT_MIPS_DEBUG,
+		0,
+		0,
+		0,
+		0,
+		4,
+		0,
+		mdebug.GenerateMDebug(
+			buildProcedures(linkName, len(input.Text), symbols.EntryPoints()),
+			data,
+			input.Lines,
+			binary.BigEndian,
+		),
+	))
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// turns a label such as paramTable or param.table into PARAM_TABLE
+func macroName(name string) string {
+	var result []byte = nil
+
+	for index := 0; index < len(name); index++ {
+		var character = name[index]
+
+		if character >= 'A' && character <= 'Z' && index > 0 {
+			var previous = name[index-1]
+
+			if previous >= 'a' && previous <= 'z' || previous >= '0' && previous <= '9' {
+				result = append(result, '_')
+			}
+		}
+
+		if character >= 'a' && character <= 'z' {
+			result = append(result, character-'a'+'A')
+		} else if character >= 'A' && character <= 'Z' || character >= '0' && character <= '9' {
+			result = append(result, character)
+		} else if len(result) > 0 && result[len(result)-1] != '_' {
+			result = append(result, '_')
+		}
+	}
+
+	return strings.Trim(string(result), "_")
+}
+
+// labels such as fooBar and foo_bar turn into the same macro
+// which the header would silently redefine
+func claimMacroName(claimed map[string]string, macro string, kind string, name string) error {
+	previous, exists := claimed[macro]
+
+	if exists {
+		return fmt.Errorf("%s %s and %s both become %s in the header, rename one of them", kind, previous, name, macro)
+	}
+
+	claimed[macro] = name
+	return nil
+}
+
+func identifierName(name string) string {
+	var result []byte = nil
+
+	for index := 0; index < len(name); index++ {
+		var character = name[index]
+
+		if character >= 'a' && character <= 'z' ||
+			character >= 'A' && character <= 'Z' ||
+			character >= '0' && character <= '9' && index > 0 {
+			result = append(result, character)
+		} else {
+			result = append(result, '_')
+		}
+	}
+
+	return string(result)
+}
+
+func dataLabels(microcode *Microcode) []SymbolDef {
+	var result []SymbolDef = nil
+
+	if microcode.Input.Symbols == nil {
+		return nil
+	}
+
+	for _, symbol := range microcode.Input.Symbols.Data {
+		if symbol.AliasOf == "" {
+			result = append(result, symbol)
+		}
+	}
+
+	return result
+}
+
+// every field is a byte array so the struct has no
+// padding without needing compiler specific attributes
+func writeDmemStruct(output *bytes.Buffer, microcode *Microcode) {
+	var offset uint32 = 0
+
+	fmt.Fprintf(output, "struct %sDmem {\n", microcode.Name)
+
+	for _, symbol := range dataLabels(microcode) {
+		if symbol.Size == 0 {
+			continue
+		}
+
+		if symbol.Value > offset {
+			fmt.Fprintf(output, "    unsigned char _pad%X[0x%X];\n", offset, symbol.Value-offset)
+		}
+
+		fmt.Fprintf(output, "    unsigned char %s[0x%X];\n", identifierName(symbol.Name), symbol.Size)
+		offset = symbol.Value + symbol.Size
+	}
+
+	if offset < uint32(len(microcode.Input.Data)) {
+		fmt.Fprintf(output, "    unsigned char _pad%X[0x%X];\n", offset, uint32(len(microcode.Input.Data))-offset)
+	}
+
+	output.WriteString("};\n\n")
+}
+
+func writeHeader(filename string, microcodes []*Microcode, includeStruct bool) error {
+	var output bytes.Buffer
+	var guard = "__" + macroName(path.Base(filename)) + "__"
+
+	output.WriteString("/* generated by rsp2dwarf, changes will be overwritten */\n\n")
+	fmt.Fprintf(&output, "#ifndef %s\n#define %s\n\n", guard, guard)
+
+	var prefixes = make(map[string]string)
+	var labelMacros = make(map[string]string)
+
+	for _, microcode := range microcodes {
+		var prefix = macroName(microcode.Name)
+
+		err := claimMacroName(prefixes, prefix, "Microcodes", microcode.Name)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&output, "#define %s_TEXT_SIZE 0x%X\n", prefix, len(microcode.Input.Text))
+		fmt.Fprintf(&output, "#define %s_DATA_SIZE 0x%X\n\n", prefix, len(microcode.Input.Data))
+
+		var labels = dataLabels(microcode)
+
+		for _, symbol := range labels {
+			var name = prefix + "_" + macroName(symbol.Name)
+
+			err = claimMacroName(labelMacros, name, "Data labels", symbol.Name)
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(&output, "#define %s_OFFSET 0x%X\n", name, symbol.Value)
+			fmt.Fprintf(&output, "#define %s_SIZE 0x%X\n", name, symbol.Size)
+		}
+
+		if len(labels) > 0 {
+			output.WriteString("\n")
+		}
+
+		var externs = []string{
+			microcode.Name + "TextStart",
+			microcode.Name + "TextEnd",
+			microcode.Name + "DataStart",
+			microcode.Name + "DataEnd",
+		}
+
+		for _, overlay := range microcode.Input.Overlays {
+			externs = append(externs, overlay.Name+"TextStart", overlay.Name+"TextEnd")
+		}
+
+		for _, name := range externs {
+			fmt.Fprintf(&output, "extern char %s[];\n", name)
+		}
+
+		// the type comes from ultra64.h or libdragon.h
+		if microcode.TaskFormat == taskFormatLibultra {
+			fmt.Fprintf(&output, "extern OSTask %s;\n", microcode.TaskName())
+		} else if microcode.TaskFormat == taskFormatLibdragon {
+			fmt.Fprintf(&output, "extern rsp_ucode_t %s;\n", microcode.TaskName())
+		}
+
+		output.WriteString("\n")
+
+		if includeStruct {
+			writeDmemStruct(&output, microcode)
+		}
+	}
+
+	fmt.Fprintf(&output, "#endif\n")
+
+	return ioutil.WriteFile(filename, output.Bytes(), 0664)
+}
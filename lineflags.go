@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/lambertjamesd/rsp2dwarf/disasm"
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+// uses the instructions to mark delay slots as not being
+// statements, branch targets as starting a basic block
+// and entry points as the end of the prologue
+func markLineFlags(lines []dwarf.InstructionEntry, text []byte, loadAddress uint32, symbols *SymbolTable) []dwarf.InstructionEntry {
+	var delaySlots = make(map[int]bool)
+	var branchTargets = make(map[int]bool)
+	var entryPoints = make(map[int]bool)
+	var address = imemAddress + loadAddress
+
+	for index, instruction := range disasm.DecodeText(text, address) {
+		var offset = index * 4
+
+		if instruction.HasDelaySlot() {
+			delaySlots[offset+4] = true
+		}
+
+		if instruction.HasTarget() {
+			var target = int(instruction.Target) - int(address)
+
+			if target >= 0 && target < len(text) {
+				branchTargets[target] = true
+			}
+		}
+	}
+
+	if symbols != nil {
+		for _, symbol := range symbols.EntryPoints() {
+			entryPoints[int(symbol.Value)] = true
+		}
+	}
+
+	var result = make([]dwarf.InstructionEntry, len(lines))
+
+	for index, line := range lines {
+		var offset = line.Address()
+
+		result[index] = line.WithFlags(
+			line.IsStatement() && !delaySlots[offset],
+			line.IsBlock() || branchTargets[offset],
+			line.IsPrologueEnd() || entryPoints[offset],
+		)
+	}
+
+	return result
+}
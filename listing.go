@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/disasm"
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+const listingCommand = "listing"
+
+const listingFormatText = "text"
+const listingFormatHtml = "html"
+
+type listingArgs struct {
+	input       string
+	output      string
+	inputFormat string
+	format      string
+}
+
+func parseListingArgs(args []string) (*listingArgs, error) {
+	var result = listingArgs{"", "", autoFrontend, ""}
+
+	if len(args) == 0 {
+		return nil, errors.New(`rsp2dwarf listing [-o output] [-f format] [-i assembler] input
+	-o    the file to write, defaults to standard out
+	-f    either ` + listingFormatText + ` or ` + listingFormatHtml + `, defaults to ` + listingFormatHtml + ` when output ends in .html
+	-i    assembler that produced the input, ` + autoFrontend + ` or one of ` + strings.Join(frontendNames(), ", "))
+	}
+
+	for i := 0; i < len(args); i++ {
+		var arg = args[i]
+
+		if arg[0] == '-' {
+			if i+1 >= len(args) {
+				return nil, errors.New(arg + " flag requires a parameter")
+			}
+
+			if arg == "-o" {
+				result.output = args[i+1]
+			} else if arg == "-f" {
+				result.format = args[i+1]
+			} else if arg == "-i" {
+				result.inputFormat = args[i+1]
+			} else {
+				return nil, errors.New("Unknown flag " + arg)
+			}
+
+			i++
+		} else {
+			if result.input != "" {
+				return nil, errors.New("Only one input file is allowed")
+			} else {
+				result.input = arg
+			}
+		}
+	}
+
+	if result.input == "" {
+		return nil, errors.New("An input file is required")
+	}
+
+	if result.format == "" {
+		if strings.HasSuffix(result.output, ".html") {
+			result.format = listingFormatHtml
+		} else {
+			result.format = listingFormatText
+		}
+	}
+
+	if result.format != listingFormatText && result.format != listingFormatHtml {
+		return nil, errors.New("Unknown listing format " + result.format)
+	}
+
+	return &result, nil
+}
+
+// source files are looked up as given and then next to the input
+type sourceCache struct {
+	inputDir string
+	files    map[string][]string
+}
+
+func (cache *sourceCache) line(filename string, line int) (string, bool) {
+	lines, ok := cache.files[filename]
+
+	if !ok {
+		data, err := ioutil.ReadFile(filename)
+
+		if err != nil && !path.IsAbs(filename) {
+			data, err = ioutil.ReadFile(path.Join(cache.inputDir, filename))
+		}
+
+		if err == nil {
+			lines = splitLines(string(data))
+		}
+
+		cache.files[filename] = lines
+	}
+
+	if line < 1 || line > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimRight(lines[line-1], " \t\r"), true
+}
+
+type listingRow struct {
+	labels      []string
+	source      string
+	instruction *disasm.Instruction
+	timing      instructionTiming
+}
+
+func buildListingRows(input *MicrocodeInput, inputName string) []listingRow {
+	var labels = make(map[uint32][]string)
+	var lines = make(map[int]*dwarf.InstructionEntry)
+	var sources = &sourceCache{path.Dir(inputName), make(map[string][]string)}
+
+	if input.Symbols != nil {
+		for _, symbol := range input.Symbols.Instructions {
+			labels[symbol.Value] = append(labels[symbol.Value], symbol.Name)
+		}
+	}
+
+	for index := range input.Lines {
+		var line = &input.Lines[index]
+
+		if lines[line.Address()] == nil {
+			lines[line.Address()] = line
+		}
+	}
+
+	var result []listingRow = nil
+	var lastFile = ""
+	var lastLine = 0
+	var estimate = estimateCycles(path.Base(inputName), input, 0)
+
+	var instructions = disasm.DecodeText(input.Text, imemAddress)
+
+	for index := range instructions {
+		var row listingRow
+		var offset = index * 4
+		var instruction = &instructions[index]
+
+		row.labels = labels[uint32(offset)]
+		row.instruction = instruction
+		row.timing = estimate.timings[offset/4]
+
+		var line = lines[offset]
+
+		// only show the source again once it moves to another line
+		if line != nil && (line.Filename() != lastFile || line.Line() != lastLine) {
+			row.source = fmt.Sprintf("%s:%d", line.Filename(), line.Line())
+			text, ok := sources.line(line.Filename(), line.Line())
+
+			if ok {
+				row.source += ": " + strings.TrimSpace(text)
+			}
+
+			lastFile = line.Filename()
+			lastLine = line.Line()
+		}
+
+		result = append(result, row)
+	}
+
+	return result
+}
+
+// branches to a label show the label instead of the address
+func listingSymbolizer(rows []listingRow) func(address uint32) (string, bool) {
+	var labels = make(map[uint32]string)
+
+	for _, row := range rows {
+		if len(row.labels) > 0 {
+			labels[row.instruction.Address] = row.labels[0]
+		}
+	}
+
+	return func(address uint32) (string, bool) {
+		label, ok := labels[address]
+		return label, ok
+	}
+}
+
+func writeTextListing(output *bytes.Buffer, rows []listingRow) {
+	var symbolize = listingSymbolizer(rows)
+
+	for _, row := range rows {
+		for _, label := range row.labels {
+			fmt.Fprintf(output, "\n%s:\n", label)
+		}
+
+		if row.source != "" {
+			fmt.Fprintf(output, "; %s\n", row.source)
+		}
+
+		var instruction = row.instruction
+		fmt.Fprintf(output, "%08X  %08X  %s", instruction.Address, instruction.Word, instruction.Format(symbolize))
+
+		if row.timing.stall > 0 {
+			fmt.Fprintf(output, "  ; %d cycle stall, %s", row.timing.stall, row.timing.reason)
+		}
+
+		output.WriteString("\n")
+	}
+}
+
+func writeHtmlListing(output *bytes.Buffer, rows []listingRow, title string) {
+	var symbolize = listingSymbolizer(rows)
+
+	fmt.Fprintf(output, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: monospace; }
+.label { font-weight: bold; }
+.source { color: #808080; }
+.address { color: #0000A0; }
+.stall { color: #C00000; }
+</style>
+</head>
+<body>
+<pre>
+`, html.EscapeString(title))
+
+	for _, row := range rows {
+		for _, label := range row.labels {
+			fmt.Fprintf(output, "\n<span class=\"label\" id=\"%s\">%s:</span>\n", html.EscapeString(label), html.EscapeString(label))
+		}
+
+		if row.source != "" {
+			fmt.Fprintf(output, "<span class=\"source\">; %s</span>\n", html.EscapeString(row.source))
+		}
+
+		var instruction = row.instruction
+		var text = html.EscapeString(instruction.Format(nil))
+		label, hasLabel := symbolize(instruction.Target)
+
+		if instruction.HasTarget() && hasLabel {
+			// link the target to where it is in the listing
+			text = html.EscapeString(instruction.Format(symbolize))
+			var escaped = html.EscapeString(label)
+			text = strings.TrimSuffix(text, escaped) + fmt.Sprintf("<a href=\"#%s\">%s</a>", escaped, escaped)
+		}
+
+		fmt.Fprintf(output, "<span class=\"address\">%08X</span>  %08X  %s", instruction.Address, instruction.Word, text)
+
+		if row.timing.stall > 0 {
+			fmt.Fprintf(output, "  <span class=\"stall\">; %d cycle stall, %s</span>", row.timing.stall, html.EscapeString(row.timing.reason))
+		}
+
+		output.WriteString("\n")
+	}
+
+	output.WriteString("</pre>\n</body>\n</html>\n")
+}
+
+func writeListing(args *listingArgs) error {
+	frontend, err := findFrontend(args.inputFormat, args.input, DebugAll)
+
+	if err != nil {
+		return err
+	}
+
+	input, err := frontend.Load(args.input, DebugAll, 0)
+
+	if err != nil {
+		return err
+	}
+
+	var rows = buildListingRows(input, args.input)
+	var output bytes.Buffer
+
+	if args.format == listingFormatHtml {
+		writeHtmlListing(&output, rows, path.Base(args.input))
+	} else {
+		writeTextListing(&output, rows)
+	}
+
+	if args.output == "" {
+		_, err = os.Stdout.Write(output.Bytes())
+		return err
+	}
+
+	return ioutil.WriteFile(args.output, output.Bytes(), 0664)
+}
+
+func runListing(args []string) error {
+	listing, err := parseListingArgs(args)
+
+	if err != nil {
+		return err
+	}
+
+	return writeListing(listing)
+}
@@ -0,0 +1,390 @@
+package mdebug
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+const magicSym = 0x7009
+const versionStamp = 0x030b
+const headerSize = 0x60
+
+const indexNil = 0xfffff
+const ifdNil = -1
+
+type ST uint8
+
+const (
+	stNil        ST = 0
+	stGlobal     ST = 1
+	stStatic     ST = 2
+	stParam      ST = 3
+	stLocal      ST = 4
+	stLabel      ST = 5
+	stProc       ST = 6
+	stBlock      ST = 7
+	stEnd        ST = 8
+	stMember     ST = 9
+	stTypedef    ST = 10
+	stFile       ST = 11
+	stStaticProc ST = 14
+	stConstant   ST = 15
+)
+
+type SC uint8
+
+const (
+	scNil       SC = 0
+	scText      SC = 1
+	scData      SC = 2
+	scBss       SC = 3
+	scRegister  SC = 4
+	scAbs       SC = 5
+	scUndefined SC = 6
+	scInfo      SC = 11
+)
+
+const langAssembler = 3
+const glevel2 = 0
+
+type Procedure struct {
+	Name     string
+	Address  uint32
+	Size     uint32
+	External bool
+}
+
+type DataSymbol struct {
+	Name    string
+	Address uint32
+	Size    uint32
+}
+
+type symbolRecord struct {
+	iss   uint32
+	value uint32
+	st    ST
+	sc    SC
+	index uint32
+}
+
+type externalRecord struct {
+	ifd int16
+	sym symbolRecord
+}
+
+type procedureRecord struct {
+	adr          uint32
+	isym         uint32
+	iline        uint32
+	lnLow        int32
+	lnHigh       int32
+	cbLineOffset uint32
+}
+
+type fileRecord struct {
+	adr          uint32
+	rss          uint32
+	issBase      uint32
+	cbSs         uint32
+	isymBase     uint32
+	csym         uint32
+	ilineBase    uint32
+	cline        uint32
+	ipdFirst     uint16
+	cpd          uint16
+	iauxBase     uint32
+	caux         uint32
+	cbLineOffset uint32
+	cbLine       uint32
+}
+
+type sourceFile struct {
+	name       string
+	procedures []Procedure
+}
+
+type builder struct {
+	lines      bytes.Buffer
+	lineCount  uint32
+	procedures []procedureRecord
+	symbols    []symbolRecord
+	aux        []uint32
+	strings    []byte
+	extStrings []byte
+	files      []fileRecord
+	externals  []externalRecord
+}
+
+func addString(data []byte, value string) ([]byte, uint32) {
+	var result = uint32(len(data))
+	data = append(data, []byte(value)...)
+	data = append(data, 0)
+	return data, result
+}
+
+type procedureByAddress []Procedure
+
+func (arr procedureByAddress) Len() int {
+	return len(arr)
+}
+
+func (arr procedureByAddress) Less(i, j int) bool {
+	return arr[i].Address < arr[j].Address
+}
+
+func (arr procedureByAddress) Swap(i, j int) {
+	arr[i], arr[j] = arr[j], arr[i]
+}
+
+func fileForAddress(instructions []dwarf.InstructionEntry, address uint32) string {
+	var result = ""
+
+	for _, inst := range instructions {
+		if uint32(inst.Address()) > address {
+			break
+		}
+
+		result = inst.Filename()
+	}
+
+	if result == "" && len(instructions) > 0 {
+		result = instructions[0].Filename()
+	}
+
+	return result
+}
+
+func groupByFile(procedures []Procedure, instructions []dwarf.InstructionEntry) []sourceFile {
+	var result []sourceFile = nil
+
+	for _, proc := range procedures {
+		var filename = fileForAddress(instructions, proc.Address)
+		var found = false
+
+		for index := range result {
+			if result[index].name == filename {
+				result[index].procedures = append(result[index].procedures, proc)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			result = append(result, sourceFile{filename, []Procedure{proc}})
+		}
+	}
+
+	return result
+}
+
+func (b *builder) addFile(file sourceFile, instructions []dwarf.InstructionEntry) {
+	var record fileRecord
+
+	record.issBase = uint32(len(b.strings))
+	record.isymBase = uint32(len(b.symbols))
+	record.ilineBase = b.lineCount
+	record.ipdFirst = uint16(len(b.procedures))
+	record.cpd = uint16(len(file.procedures))
+	record.iauxBase = uint32(len(b.aux))
+	record.cbLineOffset = uint32(b.lines.Len())
+
+	if len(file.procedures) > 0 {
+		record.adr = file.procedures[0].Address
+	}
+
+	var localStrings = make([]byte, 1)
+	var fileName uint32
+	localStrings, fileName = addString(localStrings, file.name)
+	record.rss = fileName
+
+	var localSymbols []symbolRecord = nil
+	var localAux []uint32 = nil
+
+	localSymbols = append(localSymbols, symbolRecord{fileName, 0, stFile, scText, 0})
+
+	for _, proc := range file.procedures {
+		var name uint32
+		localStrings, name = addString(localStrings, proc.Name)
+
+		var procSymbol = uint32(len(localSymbols))
+		var procType = stStaticProc
+
+		if proc.External {
+			procType = stProc
+		}
+
+		localSymbols = append(localSymbols, symbolRecord{name, proc.Address, procType, scText, uint32(len(localAux))})
+		localSymbols = append(localSymbols, symbolRecord{name, proc.Size, stEnd, scText, procSymbol})
+
+		// first aux entry is the symbol following the end
+		// of the procedure and the second is the return type
+		localAux = append(localAux, uint32(len(localSymbols)), 0)
+
+		var lineStart = b.lineCount - record.ilineBase
+		var lineOffset = uint32(b.lines.Len()) - record.cbLineOffset
+		var lineCount, lnLow, lnHigh = writeProcedureLines(&b.lines, proc, instructions)
+		b.lineCount += lineCount
+
+		b.procedures = append(b.procedures, procedureRecord{
+			proc.Address,
+			procSymbol,
+			lineStart,
+			lnLow,
+			lnHigh,
+			lineOffset,
+		})
+
+		if proc.External {
+			var extName uint32
+			b.extStrings, extName = addString(b.extStrings, proc.Name)
+			b.externals = append(b.externals, externalRecord{
+				int16(len(b.files)),
+				symbolRecord{extName, proc.Address, stProc, scText, indexNil},
+			})
+		}
+	}
+
+	localSymbols = append(localSymbols, symbolRecord{fileName, 0, stEnd, scText, 0})
+	localSymbols[0].index = uint32(len(localSymbols))
+
+	record.cbSs = uint32(len(localStrings))
+	record.csym = uint32(len(localSymbols))
+	record.caux = uint32(len(localAux))
+	record.cline = b.lineCount - record.ilineBase
+	record.cbLine = uint32(b.lines.Len()) - record.cbLineOffset
+
+	b.strings = append(b.strings, localStrings...)
+	b.symbols = append(b.symbols, localSymbols...)
+	b.aux = append(b.aux, localAux...)
+	b.files = append(b.files, record)
+}
+
+func (b *builder) addData(data DataSymbol) {
+	var name uint32
+	b.extStrings, name = addString(b.extStrings, data.Name)
+	b.externals = append(b.externals, externalRecord{
+		ifdNil,
+		symbolRecord{name, data.Address, stGlobal, scData, indexNil},
+	})
+}
+
+func align4(buffer *bytes.Buffer) {
+	for buffer.Len()%4 != 0 {
+		buffer.WriteByte(0)
+	}
+}
+
+type instructionByAddress []dwarf.InstructionEntry
+
+func (arr instructionByAddress) Len() int {
+	return len(arr)
+}
+
+func (arr instructionByAddress) Less(i, j int) bool {
+	return arr[i].Address() < arr[j].Address()
+}
+
+func (arr instructionByAddress) Swap(i, j int) {
+	arr[i], arr[j] = arr[j], arr[i]
+}
+
+func GenerateMDebug(procedures []Procedure, data []DataSymbol, instructions []dwarf.InstructionEntry, byteOrder binary.ByteOrder) []byte {
+	var b builder
+	var sorted procedureByAddress = append([]Procedure(nil), procedures...)
+	var sortedInstructions instructionByAddress = append([]dwarf.InstructionEntry(nil), instructions...)
+
+	sort.Sort(sorted)
+	sort.Stable(sortedInstructions)
+	instructions = sortedInstructions
+
+	b.extStrings = make([]byte, 1)
+
+	for _, file := range groupByFile(sorted, instructions) {
+		b.addFile(file, instructions)
+	}
+
+	for _, dataSymbol := range data {
+		b.addData(dataSymbol)
+	}
+
+	var body bytes.Buffer
+
+	body.Write(b.lines.Bytes())
+	align4(&body)
+	var pdOffset = body.Len()
+	for _, proc := range b.procedures {
+		proc.serialize(&body, byteOrder)
+	}
+	var symOffset = body.Len()
+	for _, sym := range b.symbols {
+		sym.serialize(&body, byteOrder)
+	}
+	var auxOffset = body.Len()
+	for _, aux := range b.aux {
+		binary.Write(&body, byteOrder, &aux)
+	}
+	var ssOffset = body.Len()
+	body.Write(b.strings)
+	align4(&body)
+	var ssExtOffset = body.Len()
+	body.Write(b.extStrings)
+	align4(&body)
+	var fdOffset = body.Len()
+	for _, file := range b.files {
+		file.serialize(&body, byteOrder)
+	}
+	var extOffset = body.Len()
+	for _, ext := range b.externals {
+		ext.serialize(&body, byteOrder)
+	}
+
+	var sectionOffset = func(count int, offset int) int32 {
+		if count == 0 {
+			return 0
+		}
+
+		return int32(offset + headerSize)
+	}
+
+	var result bytes.Buffer
+
+	var header = []int32{
+		int32(b.lineCount),
+		int32(b.lines.Len()),
+		sectionOffset(b.lines.Len(), 0),
+		0, // dense numbers
+		0,
+		int32(len(b.procedures)),
+		sectionOffset(len(b.procedures), pdOffset),
+		int32(len(b.symbols)),
+		sectionOffset(len(b.symbols), symOffset),
+		0, // optimization symbols
+		0,
+		int32(len(b.aux)),
+		sectionOffset(len(b.aux), auxOffset),
+		int32(len(b.strings)),
+		sectionOffset(len(b.strings), ssOffset),
+		int32(len(b.extStrings)),
+		sectionOffset(len(b.extStrings), ssExtOffset),
+		int32(len(b.files)),
+		sectionOffset(len(b.files), fdOffset),
+		0, // relative file descriptors
+		0,
+		int32(len(b.externals)),
+		sectionOffset(len(b.externals), extOffset),
+	}
+
+	var magic uint16 = magicSym
+	binary.Write(&result, byteOrder, &magic)
+	var vstamp uint16 = versionStamp
+	binary.Write(&result, byteOrder, &vstamp)
+	binary.Write(&result, byteOrder, header)
+
+	result.Write(body.Bytes())
+
+	return result.Bytes()
+}
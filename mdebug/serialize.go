@@ -0,0 +1,156 @@
+package mdebug
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+func (sym *symbolRecord) serialize(writer io.Writer, byteOrder binary.ByteOrder) {
+	binary.Write(writer, byteOrder, &sym.iss)
+	binary.Write(writer, byteOrder, &sym.value)
+
+	var bits = []byte{
+		(byte(sym.st) << 2) | ((byte(sym.sc) >> 3) & 0x03),
+		((byte(sym.sc) << 5) & 0xE0) | byte((sym.index>>16)&0x0F),
+		byte(sym.index >> 8),
+		byte(sym.index),
+	}
+
+	writer.Write(bits)
+}
+
+func (ext *externalRecord) serialize(writer io.Writer, byteOrder binary.ByteOrder) {
+	// jmptbl, cobol_main and weakext flags are never set
+	writer.Write([]byte{0, 0})
+	binary.Write(writer, byteOrder, &ext.ifd)
+	ext.sym.serialize(writer, byteOrder)
+}
+
+func (proc *procedureRecord) serialize(writer io.Writer, byteOrder binary.ByteOrder) {
+	var zero uint32 = 0
+	var pcReg uint16 = 31
+	var frameReg uint16 = 29
+
+	binary.Write(writer, byteOrder, &proc.adr)
+	binary.Write(writer, byteOrder, &proc.isym)
+	binary.Write(writer, byteOrder, &proc.iline)
+	binary.Write(writer, byteOrder, &zero) // regmask
+	binary.Write(writer, byteOrder, &zero) // regoffset
+	var iopt int32 = -1
+	binary.Write(writer, byteOrder, &iopt)
+	binary.Write(writer, byteOrder, &zero) // fregmask
+	binary.Write(writer, byteOrder, &zero) // fregoffset
+	binary.Write(writer, byteOrder, &zero) // frameoffset
+	binary.Write(writer, byteOrder, &frameReg)
+	binary.Write(writer, byteOrder, &pcReg)
+	binary.Write(writer, byteOrder, &proc.lnLow)
+	binary.Write(writer, byteOrder, &proc.lnHigh)
+	binary.Write(writer, byteOrder, &proc.cbLineOffset)
+}
+
+func (file *fileRecord) serialize(writer io.Writer, byteOrder binary.ByteOrder) {
+	var zero uint32 = 0
+
+	binary.Write(writer, byteOrder, &file.adr)
+	binary.Write(writer, byteOrder, &file.rss)
+	binary.Write(writer, byteOrder, &file.issBase)
+	binary.Write(writer, byteOrder, &file.cbSs)
+	binary.Write(writer, byteOrder, &file.isymBase)
+	binary.Write(writer, byteOrder, &file.csym)
+	binary.Write(writer, byteOrder, &file.ilineBase)
+	binary.Write(writer, byteOrder, &file.cline)
+	binary.Write(writer, byteOrder, &zero) // ioptBase
+	binary.Write(writer, byteOrder, &zero) // copt
+	binary.Write(writer, byteOrder, &file.ipdFirst)
+	binary.Write(writer, byteOrder, &file.cpd)
+	binary.Write(writer, byteOrder, &file.iauxBase)
+	binary.Write(writer, byteOrder, &file.caux)
+	binary.Write(writer, byteOrder, &zero) // rfdBase
+	binary.Write(writer, byteOrder, &zero) // crfd
+
+	var bigEndian byte = 0
+
+	if byteOrder == binary.BigEndian {
+		bigEndian = 1
+	}
+
+	writer.Write([]byte{
+		(langAssembler << 3) | bigEndian,
+		glevel2 << 6,
+		0,
+		0,
+	})
+
+	binary.Write(writer, byteOrder, &file.cbLineOffset)
+	binary.Write(writer, byteOrder, &file.cbLine)
+}
+
+func writeLineRun(writer *bytes.Buffer, delta int, count int) {
+	if delta >= -7 && delta <= 7 {
+		writer.WriteByte(byte((delta&0xF)<<4) | byte(count-1))
+	} else {
+		writer.WriteByte(0x80 | byte(count-1))
+		writer.WriteByte(byte(delta >> 8))
+		writer.WriteByte(byte(delta))
+	}
+}
+
+func lineForAddress(instructions []dwarf.InstructionEntry, address uint32, fallback int) int {
+	var result = fallback
+
+	for _, inst := range instructions {
+		if uint32(inst.Address()) > address {
+			break
+		}
+
+		result = inst.Line()
+	}
+
+	return result
+}
+
+// each byte encodes a line delta in the upper nibble and
+// a count of instructions sharing that line in the lower
+func writeProcedureLines(writer *bytes.Buffer, proc Procedure, instructions []dwarf.InstructionEntry) (uint32, int32, int32) {
+	var wordCount = proc.Size / 4
+
+	if wordCount == 0 {
+		return 0, 0, 0
+	}
+
+	var lines = make([]int, wordCount)
+	var lnLow = 0
+	var lnHigh = 0
+
+	for index := range lines {
+		lines[index] = lineForAddress(instructions, proc.Address+uint32(index)*4, 0)
+
+		if index == 0 || lines[index] < lnLow {
+			lnLow = lines[index]
+		}
+
+		if index == 0 || lines[index] > lnHigh {
+			lnHigh = lines[index]
+		}
+	}
+
+	var current = lnLow
+	var index = 0
+
+	for index < len(lines) {
+		var runLength = 1
+
+		for index+runLength < len(lines) && runLength < 16 && lines[index+runLength] == lines[index] {
+			runLength++
+		}
+
+		writeLineRun(writer, lines[index]-current, runLength)
+		current = lines[index]
+		index += runLength
+	}
+
+	return wordCount, int32(lnLow), int32(lnHigh)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+const imemSize = 0x1000
+const imemAddress = 0x04001000
+const overlaySectionPrefix = ".ovly."
+
+// a block of code that is copied into IMEM over
+// part of the microcode while it is running
+type Overlay struct {
+	Name        string
+	LoadAddress uint32
+	Input       *MicrocodeInput
+}
+
+func (overlay *Overlay) SectionName() string {
+	return overlaySectionPrefix + overlay.Name
+}
+
+func (overlay *Overlay) LoadStartSymbol() string {
+	return loadStartSymbol(overlay.SectionName())
+}
+
+// GNU ld defines __load_start_<section> for each section in an OVERLAY
+// statement, leaving out characters that can't be in a C identifier
+func loadStartSymbol(section string) string {
+	return "__load_start_" + strings.Map(func(char rune) rune {
+		if char == '_' || char >= '0' && char <= '9' || char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' {
+			return char
+		}
+
+		return -1
+	}, section)
+}
+
+// where in IMEM the overlay in section runs, a linked file has it as
+// the address of the section while an object only has it in _ovly_table
+func findOverlayLoadAddress(elfFile *elf.ElfFile, elfSymbols []elf.ElfSymbol, section string) (uint32, bool) {
+	var index = elfFile.FindSectionIndex(section)
+
+	if index == -1 {
+		return 0, false
+	}
+
+	if elfFile.Header.Type() == elf.ET_EXEC {
+		return elfFile.Sections[index].Address & (imemSize - 1), true
+	}
+
+	var table = sectionData(elfFile, ".ovly_table")
+	var relocations = sectionData(elfFile, ".rel.ovly_table")
+	var byteOrder = elfFile.Header.ByteOrder()
+
+	// the lma of each entry is relocated against the load start symbol
+	for offset := 0; offset+8 <= len(relocations); offset += 8 {
+		var entry = int(byteOrder.Uint32(relocations[offset:])) - 8
+		var symbolIndex = int(byteOrder.Uint32(relocations[offset+4:]) >> 8)
+
+		if entry < 0 || entry+4 > len(table) || symbolIndex >= len(elfSymbols) {
+			continue
+		}
+
+		if elfSymbols[symbolIndex].Name == loadStartSymbol(section) {
+			return (byteOrder.Uint32(table[entry:]) - imemAddress) & (imemSize - 1), true
+		}
+	}
+
+	return 0, false
+}
+
+func isOverlaySection(name string) bool {
+	return strings.HasPrefix(name, overlaySectionPrefix)
+}
+
+// overlays are given as input@address where address is
+// where the overlay is loaded in IMEM
+func parseOverlayArg(arg string) (string, uint32, error) {
+	var separator = strings.LastIndex(arg, "@")
+
+	if separator == -1 {
+		return "", 0, errors.New("Overlay " + arg + " needs a load address, use -v input@address")
+	}
+
+	address, err := parseMaybeHex(arg[separator+1:], 32)
+
+	if err != nil || address < 0 || address >= imemSize {
+		return "", 0, errors.New("Invalid IMEM load address for overlay " + arg)
+	}
+
+	return arg[:separator], uint32(address), nil
+}
+
+func loadOverlays(overlayArgs []string, main *MicrocodeInput, mainName string, inputFormat string, content DebugContent) error {
+	var names = map[string]bool{mainName: true}
+
+	for _, arg := range overlayArgs {
+		filename, loadAddress, err := parseOverlayArg(arg)
+
+		if err != nil {
+			return err
+		}
+
+		frontend, err := findFrontend(inputFormat, filename, content)
+
+		if err != nil {
+			return err
+		}
+
+		input, err := frontend.Load(filename, content, loadAddress)
+
+		if err != nil {
+			return err
+		}
+
+		if int(loadAddress)+len(input.Text) > imemSize {
+			return fmt.Errorf("Overlay %s is 0x%X bytes and doesn't fit in IMEM at 0x%X", filename, len(input.Text), loadAddress)
+		}
+
+		if len(input.Data) > 0 {
+			reportWarnings([]diagnostic{createDiagnostic(filename, 0, "ignoring DMEM contents, overlays only replace IMEM")})
+		}
+
+		var name = linkNameFromFileName(filename)
+
+		if names[name] {
+			return errors.New("Overlay " + filename + " would use the link name " + name + " more than once")
+		}
+
+		names[name] = true
+
+		main.Overlays = append(main.Overlays, &Overlay{name, loadAddress, input})
+	}
+
+	return nil
+}
+
+// gdb finds overlays through _ovly_table, an array of
+// { vma, size, lma, mapped } and _novlys, the number of entries
+// the code loading an overlay sets mapped while it is resident
+func buildOverlayTable(overlays []*Overlay) elf.ElfSection {
+	var table bytes.Buffer
+
+	for _, overlay := range overlays {
+		var entry = []uint32{
+			imemAddress + overlay.LoadAddress,
+			uint32(len(overlay.Input.Text)),
+			0,
+			0,
+		}
+
+		binary.Write(&table, binary.BigEndian, entry)
+	}
+
+	var count = uint32(len(overlays))
+	binary.Write(&table, binary.BigEndian, &count)
+
+	return elf.BuildElfSection(
+		".ovly_table",
+		elf.SHT_PROGBITS,
+		elf.SHF_WRITE|elf.SHF_ALLOC,
+		0,
+		0,
+		0,
+		4,
+		0,
+		table.Bytes(),
+	)
+}
+
+// the lma is where the overlay is in RDRAM, which only the linker
+// script knows, so it points at a weak symbol the script can define
+func buildOverlayLoadSymbols(overlays []*Overlay) []elf.ElfSymbol {
+	var result []elf.ElfSymbol = nil
+
+	for _, overlay := range overlays {
+		result = append(result, elf.BuildSymbol(overlay.LoadStartSymbol(), 0, 0, elf.STB_WEAK, elf.STT_NOTYPE, 0, elf.SHN_UNDEF))
+	}
+
+	return result
+}
+
+// has to run after every symbol is added so the indices are final
+func appendOverlayTableRelocations(elfFile *elf.ElfFile, overlays []*Overlay) {
+	var relocations = elf.NewRelocationBuilder()
+	var symbolMapping = make(map[string]uint32)
+
+	for index, overlay := range overlays {
+		symbolMapping[overlay.LoadStartSymbol()] = uint32(elfFile.SymbolIndex(overlay.LoadStartSymbol()))
+		relocations.AddEntry(uint32(index*16+8), overlay.LoadStartSymbol(), elf.R_MIPS_32)
+	}
+
+	elfFile.Sections = append(elfFile.Sections, relocations.ToElfSection(".ovly_table", symbolMapping, binary.BigEndian))
+}
+
+func buildOverlaySymbols(overlay *Overlay, section uint16, includeDebug bool) []elf.ElfSymbol {
+	var textLength = uint32(len(overlay.Input.Text))
+
+	var result = []elf.ElfSymbol{
+		elf.BuildSymbol(overlay.Name+"TextStart", 0, textLength, elf.STB_GLOBAL, elf.STT_FUNC, 0, section),
+		elf.BuildSymbol(overlay.Name+"TextEnd", textLength, 0, elf.STB_GLOBAL, elf.STT_FUNC, 0, section),
+	}
+
+	if includeDebug && overlay.Input.Symbols != nil {
+		result = append(result, buildDbgSymbols(&SymbolTable{overlay.Input.Symbols.Instructions, nil, nil}, section, 0)...)
+	}
+
+	return result
+}
+
+func buildOverlayTableSymbols(count int, tableSection uint16) []elf.ElfSymbol {
+	var tableSize = uint32(count * 16)
+
+	return []elf.ElfSymbol{
+		elf.BuildSymbol("_ovly_table", 0, tableSize, elf.STB_GLOBAL, elf.STT_OBJECT, 0, tableSection),
+		elf.BuildSymbol("_novlys", tableSize, 4, elf.STB_GLOBAL, elf.STT_OBJECT, 0, tableSection),
+	}
+}
+
+// each microcode and overlay gets its own compilation unit and line sequence
+type debugUnit struct {
+	section  string
+	length   int
+	lines    []dwarf.InstructionEntry
+	producer string
+	calls    *callGraph
+	// data labels are only described in the microcode's unit
+	data        *SymbolTable
+	dataSection string
+	references  []dataReference
+}
+
+func buildDebugUnits(microcodes []*Microcode) []debugUnit {
+	var result []debugUnit = nil
+
+	for _, microcode := range microcodes {
+		var input = microcode.Input
+		// the first graph is the microcode followed by each overlay
+		var graphs = buildCallGraphs(microcode)
+		var xrefs = buildDataXrefs(microcode)
+
+		if len(input.Lines) > 0 {
+			var lines = markLineFlags(input.Lines, input.Text, microcode.LoadAddress, input.Symbols)
+			result = append(result, debugUnit{microcode.TextSection, len(input.Text), lines, input.Producer, graphs[0], input.Symbols, microcode.DataSection, xrefs[0].References})
+		}
+
+		for index, overlay := range input.Overlays {
+			if len(overlay.Input.Lines) > 0 {
+				var lines = markLineFlags(overlay.Input.Lines, overlay.Input.Text, overlay.LoadAddress, overlay.Input.Symbols)
+				result = append(result, debugUnit{overlay.SectionName(), len(overlay.Input.Text), lines, overlay.Input.Producer, graphs[index+1], nil, "", xrefs[index+1].References})
+			}
+		}
+	}
+
+	return result
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"errors"
 	"sort"
 	"strconv"
 	"strings"
@@ -10,56 +9,137 @@ import (
 )
 
 func parseMaybeHex(input string, bitSize int) (int64, error) {
-	if input[0:2] == "0x" || input[0:2] == "0X" {
+	if len(input) > 2 && (input[0:2] == "0x" || input[0:2] == "0X") {
 		return strconv.ParseInt(input[2:], 16, bitSize)
 	} else {
 		return strconv.ParseInt(input, 10, bitSize)
 	}
 }
 
-func parseSymFile(input string) ([]dwarf.InstructionEntry, error) {
-	var lines = strings.Split(input, "\n")
+// line <address> <file> <line> [column]
+func parseSymLine(parts []string, filename string, lineNumber int) (dwarf.InstructionEntry, error) {
+	if len(parts) < 4 {
+		return dwarf.InstructionEntry{}, createDiagnostic(filename, lineNumber, "line record should have at least 3 arguments")
+	}
+
+	if len(parts) > 5 {
+		return dwarf.InstructionEntry{}, createDiagnostic(filename, lineNumber, "line record has too many arguments")
+	}
+
+	addr, err := parseMaybeHex(parts[1], 32)
+
+	if err != nil || addr < 0 {
+		return dwarf.InstructionEntry{}, createDiagnostic(filename, lineNumber, "invalid address '%s'", parts[1])
+	}
+
+	sourceLine, err := strconv.ParseInt(parts[3], 10, 32)
+
+	if err != nil || sourceLine < 0 {
+		return dwarf.InstructionEntry{}, createDiagnostic(filename, lineNumber, "invalid line number '%s'", parts[3])
+	}
+
+	var column int64 = 0
+
+	if len(parts) == 5 {
+		column, err = strconv.ParseInt(parts[4], 10, 32)
 
+		if err != nil || column < 0 {
+			return dwarf.InstructionEntry{}, createDiagnostic(filename, lineNumber, "invalid column '%s'", parts[4])
+		}
+	}
+
+	return dwarf.CreateInstructionEntry(
+		int(addr),
+		parts[2],
+		int(sourceLine),
+		int(column),
+		true,
+		false,
+	), nil
+}
+
+func parseSymFile(filename string, input string) ([]dwarf.InstructionEntry, []diagnostic, error) {
 	var result []dwarf.InstructionEntry = nil
+	var warnings []diagnostic = nil
+	var unknownRecords = make(map[string]bool)
 
-	for _, line := range lines {
-		var parts = strings.Split(line, " ")
+	for index, line := range splitLines(input) {
+		var lineNumber = index + 1
 
-		if parts[0] == "line" {
-			if len(parts) < 4 {
-				return nil, errors.New("Line should have at least 4 arguments")
-			}
+		parts, err := tokenizeLine(line)
 
-			addr, err := parseMaybeHex(parts[1], 32)
+		if err != nil {
+			return nil, warnings, createDiagnostic(filename, lineNumber, "%s", err.Error())
+		}
 
-			if err != nil {
-				return nil, err
-			}
+		if len(parts) == 0 {
+			continue
+		}
 
-			lineNumber, err := strconv.ParseInt(parts[3], 10, 32)
+		if parts[0] == "line" {
+			entry, err := parseSymLine(parts, filename, lineNumber)
 
 			if err != nil {
-				return nil, err
+				return nil, warnings, err
 			}
 
-			result = append(result, dwarf.CreateInstructionEntry(
-				int(addr),
-				parts[2],
-				int(lineNumber),
-				0,
-				true,
-				false,
-			))
+			result = append(result, entry)
+		} else if !unknownRecords[parts[0]] {
+			// newer versions of rspasm may add records, only warn once for each
+			unknownRecords[parts[0]] = true
+			warnings = append(warnings, createDiagnostic(filename, lineNumber, "ignoring unknown record type '%s'", parts[0]))
 		}
 	}
 
-	return result, nil
+	if len(result) == 0 {
+		return nil, warnings, createDiagnostic(filename, 0, "no line records found")
+	}
+
+	return result, warnings, nil
+}
+
+type SymbolKind int
+
+const (
+	SymbolEntryPoint SymbolKind = iota
+	SymbolLocalLabel
+	SymbolData
+	SymbolEquate
+	// data symbols that were local in an input elf file
+	SymbolLocalData
+)
+
+func isDataKind(kind SymbolKind) bool {
+	return kind == SymbolData || kind == SymbolLocalData
 }
 
 type SymbolDef struct {
 	Name  string
 	Value uint32
 	Size  uint32
+	Kind  SymbolKind
+	// name of the symbol at the same address this one is an alias of
+	AliasOf string
+	// line in the .dbg file the symbol was defined on
+	Line int
+}
+
+type SymbolTable struct {
+	Instructions []SymbolDef
+	Data         []SymbolDef
+	Equates      []SymbolDef
+}
+
+func (table *SymbolTable) EntryPoints() []SymbolDef {
+	var result []SymbolDef = nil
+
+	for _, symbol := range table.Instructions {
+		if symbol.Kind == SymbolEntryPoint && symbol.AliasOf == "" {
+			result = append(result, symbol)
+		}
+	}
+
+	return result
 }
 
 type SortSymbolsByValue []SymbolDef
@@ -76,61 +156,188 @@ func (arr SortSymbolsByValue) Swap(i, j int) {
 	arr[i], arr[j] = arr[j], arr[i]
 }
 
-func assignRanges(instructionSymbols SortSymbolsByValue, size int) []SymbolDef {
-	sort.Sort(instructionSymbols)
+// sizes each sized symbol up to the next sized symbol at a
+// different address, labels sharing an address become aliases
+// of the first one so they all cover the same range
+func assignRanges(symbols SortSymbolsByValue, size int) []SymbolDef {
+	sort.Stable(symbols)
+
+	var sized []int = nil
 
-	for index, _ := range instructionSymbols {
-		if index != 0 {
-			instructionSymbols[index-1].Size = instructionSymbols[index].Value - instructionSymbols[index-1].Value
+	for index, symbol := range symbols {
+		if symbol.Kind != SymbolLocalLabel {
+			sized = append(sized, index)
 		}
 	}
 
-	if len(instructionSymbols) > 0 {
-		var lastEntry = &instructionSymbols[len(instructionSymbols)-1]
-		lastEntry.Size = uint32(size) - lastEntry.Value
+	for sizedIndex, index := range sized {
+		var symbol = &symbols[index]
+		var end = uint32(size)
+
+		for _, nextIndex := range sized[sizedIndex+1:] {
+			if symbols[nextIndex].Value != symbol.Value {
+				end = symbols[nextIndex].Value
+				break
+			}
+		}
+
+		if end > symbol.Value {
+			symbol.Size = end - symbol.Value
+		}
+
+		if sizedIndex > 0 && symbols[sized[sizedIndex-1]].Value == symbol.Value {
+			var primary = &symbols[sized[sizedIndex-1]]
+
+			if primary.AliasOf != "" {
+				symbol.AliasOf = primary.AliasOf
+			} else {
+				symbol.AliasOf = primary.Name
+			}
+		}
+	}
+
+	return symbols
+}
+
+func isLocalLabelName(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "@")
+}
+
+// <name> <hex address> <kind>
+//
+//	I instruction label, local if the name starts with . or @
+//	L local instruction label
+//	D data label
+//	d local data label
+//	E equate or constant
+//
+// lines that can't be used come back as a warning
+func parseDbgLine(parts []string, filename string, lineNumber int) (SymbolDef, *diagnostic) {
+	if len(parts) != 3 {
+		var warning = createDiagnostic(filename, lineNumber, "ignoring line, a symbol should have a name, address and kind")
+		return SymbolDef{}, &warning
+	}
+
+	addr, err := strconv.ParseUint(parts[1], 16, 32)
+
+	if err != nil {
+		var warning = createDiagnostic(filename, lineNumber, "ignoring symbol '%s' with invalid address '%s'", parts[0], parts[1])
+		return SymbolDef{}, &warning
 	}
 
-	return instructionSymbols
+	var result = SymbolDef{parts[0], uint32(addr), 0, SymbolEntryPoint, "", lineNumber}
+
+	switch parts[2] {
+	case "I":
+		if isLocalLabelName(parts[0]) {
+			result.Kind = SymbolLocalLabel
+		}
+	case "L":
+		result.Kind = SymbolLocalLabel
+	case "D":
+		result.Kind = SymbolData
+	case "d":
+		result.Kind = SymbolLocalData
+	case "E":
+		result.Kind = SymbolEquate
+	default:
+		var warning = createDiagnostic(filename, lineNumber, "ignoring symbol '%s' with unknown kind '%s'", parts[0], parts[2])
+		return result, &warning
+	}
+
+	return result, nil
 }
 
-func parseDbgFile(input string, textSize int, dataSize int) ([]SymbolDef, []SymbolDef) {
+func parseDbgFile(filename string, input string, textAddress uint32, textSize int, dataSize int) (*SymbolTable, []diagnostic, error) {
 	var instructionSymbols SortSymbolsByValue = nil
 	var dataSymbols SortSymbolsByValue = nil
+	var equates []SymbolDef = nil
+	var warnings []diagnostic = nil
 
-	var lines = strings.Split(input, "\n")
+	var defined = make(map[string]SymbolDef)
 
-	for _, line := range lines {
-		var parts = strings.Split(line, " ")
+	for index, line := range splitLines(input) {
+		var lineNumber = index + 1
 
-		if len(parts) == 3 {
-			addr, _ := strconv.ParseInt(parts[1], 16, 32)
+		parts, err := tokenizeLine(line)
 
-			if parts[2] == "I" {
-				instructionSymbols = append(instructionSymbols, SymbolDef{parts[0], uint32(addr), 0})
-			} else if parts[2] == "D" {
-				dataSymbols = append(dataSymbols, SymbolDef{parts[0], uint32(addr), 0})
+		if err != nil {
+			return nil, warnings, createDiagnostic(filename, lineNumber, "%s", err.Error())
+		}
+
+		if len(parts) == 0 {
+			continue
+		}
+
+		symbol, warning := parseDbgLine(parts, filename, lineNumber)
+
+		if warning != nil {
+			warnings = append(warnings, *warning)
+			continue
+		}
+
+		if isDataKind(symbol.Kind) || symbol.Kind == SymbolEquate {
+			if isDataKind(symbol.Kind) && int(symbol.Value) >= dataSize && dataSize > 0 {
+				warnings = append(warnings, createDiagnostic(filename, lineNumber, "data symbol '%s' at 0x%X is past the end of data", symbol.Name, symbol.Value))
+			}
+		} else if symbol.Value < textAddress {
+			warnings = append(warnings, createDiagnostic(filename, lineNumber, "instruction symbol '%s' at 0x%X is before the start of text at 0x%X", symbol.Name, symbol.Value, textAddress))
+			continue
+		} else {
+			symbol.Value -= textAddress
+
+			if int(symbol.Value) >= textSize {
+				warnings = append(warnings, createDiagnostic(filename, lineNumber, "instruction symbol '%s' at 0x%X is past the end of text", symbol.Name, symbol.Value+textAddress))
 			}
 		}
-	}
 
-	var finalInstruction SortSymbolsByValue = nil
+		previous, exists := defined[symbol.Name]
 
-	for _, check := range instructionSymbols {
-		var isDuplicate = false
+		if exists {
+			if !isDataKind(previous.Kind) && isDataKind(symbol.Kind) {
+				// the data definition wins over an instruction label or equate with the same name
+				var kind = "instruction symbol"
 
-		for _, dataSymbol := range dataSymbols {
-			if dataSymbol.Name == check.Name {
-				isDuplicate = true
-				break
+				if previous.Kind == SymbolEquate {
+					kind = "equate"
+				}
+
+				warnings = append(warnings, createDiagnostic(filename, previous.Line, "dropping %s '%s', it is also defined as data on line %d", kind, symbol.Name, lineNumber))
+				instructionSymbols = removeSymbol(instructionSymbols, symbol.Name)
+				equates = removeSymbol(equates, symbol.Name)
+			} else {
+				warnings = append(warnings, createDiagnostic(filename, lineNumber, "dropping symbol '%s', it was already defined on line %d", symbol.Name, previous.Line))
+				continue
 			}
 		}
 
-		if isDuplicate {
-			continue
+		defined[symbol.Name] = symbol
+
+		switch symbol.Kind {
+		case SymbolData, SymbolLocalData:
+			dataSymbols = append(dataSymbols, symbol)
+		case SymbolEquate:
+			equates = append(equates, symbol)
+		default:
+			instructionSymbols = append(instructionSymbols, symbol)
 		}
+	}
+
+	return &SymbolTable{
+		assignRanges(instructionSymbols, textSize),
+		assignRanges(dataSymbols, dataSize),
+		equates,
+	}, warnings, nil
+}
 
-		finalInstruction = append(finalInstruction, check)
+func removeSymbol(symbols []SymbolDef, name string) []SymbolDef {
+	var result []SymbolDef = nil
+
+	for _, symbol := range symbols {
+		if symbol.Name != name {
+			result = append(result, symbol)
+		}
 	}
 
-	return assignRanges(finalInstruction, textSize), assignRanges(dataSymbols, dataSize)
+	return result
 }
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const pprofCommand = "pprof"
+
+type pprofArgs struct {
+	symbolizer *symbolizerArgs
+	output     string
+	samples    []string
+}
+
+func parsePprofArgs(args []string) (*pprofArgs, error) {
+	var result = pprofArgs{nil, "profile.pb.gz", nil}
+
+	if len(args) == 0 {
+		return nil, errors.New(`rsp2dwarf pprof [-o profile.pb.gz] [-b base] [-j section] [-i assembler] input [samples...]
+	-o    where to write the profile, defaults to profile.pb.gz
+` + symbolizerUsage() + `
+	each line of a sample file is a hex PC followed by the return addresses left by jal, innermost first
+	samples are read from standard in when none are given`)
+	}
+
+	symbolizer, samples, err := parseSymbolizerArgs(args, func(flag string, value string) (bool, error) {
+		if flag == "-o" {
+			result.output = value
+			return true, nil
+		}
+
+		return false, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.symbolizer = symbolizer
+	result.samples = samples
+	return &result, nil
+}
+
+// writes the handful of protobuf encodings profile.proto needs
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (buffer *protoBuffer) varint(value uint64) {
+	for value >= 0x80 {
+		buffer.WriteByte(byte(value) | 0x80)
+		value >>= 7
+	}
+
+	buffer.WriteByte(byte(value))
+}
+
+func (buffer *protoBuffer) uintField(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+
+	buffer.varint(uint64(field) << 3)
+	buffer.varint(value)
+}
+
+func (buffer *protoBuffer) boolField(field int, value bool) {
+	if value {
+		buffer.uintField(field, 1)
+	}
+}
+
+func (buffer *protoBuffer) bytesField(field int, value []byte) {
+	buffer.varint(uint64(field)<<3 | 2)
+	buffer.varint(uint64(len(value)))
+	buffer.Write(value)
+}
+
+func (buffer *protoBuffer) packedField(field int, values []uint64) {
+	var packed protoBuffer
+
+	for _, value := range values {
+		packed.varint(value)
+	}
+
+	buffer.bytesField(field, packed.Bytes())
+}
+
+// field numbers from profile.proto in github.com/google/pprof
+const (
+	profileSampleType      = 1
+	profileSample          = 2
+	profileMapping         = 3
+	profileLocation        = 4
+	profileFunction        = 5
+	profileStringTable     = 6
+	profilePeriodType      = 11
+	profilePeriod          = 12
+	valueTypeType          = 1
+	valueTypeUnit          = 2
+	sampleLocationId       = 1
+	sampleValue            = 2
+	mappingId              = 1
+	mappingMemoryStart     = 2
+	mappingMemoryLimit     = 3
+	mappingFilename        = 5
+	mappingHasFunctions    = 7
+	mappingHasFilenames    = 8
+	mappingHasLineNumbers  = 9
+	mappingHasInlineFrames = 10
+	locationId             = 1
+	locationMappingId      = 2
+	locationAddress        = 3
+	locationLine           = 4
+	lineFunctionId         = 1
+	lineLine               = 2
+	functionId             = 1
+	functionName           = 2
+	functionSystemName     = 3
+	functionFilename       = 4
+	functionStartLine      = 5
+)
+
+type profileFunctionKey struct {
+	name     string
+	filename string
+}
+
+type profileSampleEntry struct {
+	locations []uint64
+	count     uint64
+}
+
+// collects samples into the tables of a profile, ids in
+// pprof start at 1 and string 0 is always empty
+type profileBuilder struct {
+	lookup      *symbolizer
+	base        uint32
+	strings     []string
+	stringIds   map[string]uint64
+	functions   protoBuffer
+	functionIds map[profileFunctionKey]uint64
+	locations   protoBuffer
+	locationIds map[uint32]uint64
+	samples     []*profileSampleEntry
+	sampleIds   map[string]*profileSampleEntry
+}
+
+func newProfileBuilder(lookup *symbolizer, base uint32) *profileBuilder {
+	return &profileBuilder{
+		lookup,
+		base,
+		[]string{""},
+		map[string]uint64{"": 0},
+		protoBuffer{},
+		make(map[profileFunctionKey]uint64),
+		protoBuffer{},
+		make(map[uint32]uint64),
+		nil,
+		make(map[string]*profileSampleEntry),
+	}
+}
+
+func (builder *profileBuilder) stringId(value string) uint64 {
+	id, ok := builder.stringIds[value]
+
+	if !ok {
+		id = uint64(len(builder.strings))
+		builder.strings = append(builder.strings, value)
+		builder.stringIds[value] = id
+	}
+
+	return id
+}
+
+func (builder *profileBuilder) functionId(name string, filename string, startLine int) uint64 {
+	var key = profileFunctionKey{name, filename}
+	id, ok := builder.functionIds[key]
+
+	if ok {
+		return id
+	}
+
+	id = uint64(len(builder.functionIds) + 1)
+	builder.functionIds[key] = id
+
+	var function protoBuffer
+	function.uintField(functionId, id)
+	function.uintField(functionName, builder.stringId(name))
+	function.uintField(functionSystemName, builder.stringId(name))
+	function.uintField(functionFilename, builder.stringId(filename))
+	function.uintField(functionStartLine, uint64(startLine))
+	builder.functions.bytesField(profileFunction, function.Bytes())
+
+	return id
+}
+
+// a location for each instruction with a line for each
+// inlined frame, innermost first as pprof expects
+func (builder *profileBuilder) locationId(offset uint32) uint64 {
+	id, ok := builder.locationIds[offset]
+
+	if ok {
+		return id
+	}
+
+	id = uint64(len(builder.locationIds) + 1)
+	builder.locationIds[offset] = id
+
+	var location protoBuffer
+	location.uintField(locationId, id)
+	location.uintField(locationMappingId, 1)
+	location.uintField(locationAddress, uint64(builder.base+offset))
+
+	var frames = builder.lookup.frames(offset)
+
+	for index, frame := range frames {
+		var name = frame.function
+		var startLine = 0
+
+		if index == len(frames)-1 {
+			var symbol = builder.lookup.symbolAt(offset)
+
+			if symbol != nil {
+				name = symbol.Name
+				startLine = builder.lookup.frames(symbol.Value)[0].line
+			}
+		}
+
+		var line protoBuffer
+		line.uintField(lineFunctionId, builder.functionId(name, frame.filename, startLine))
+		line.uintField(lineLine, uint64(frame.line))
+		location.bytesField(locationLine, line.Bytes())
+	}
+
+	builder.locations.bytesField(profileLocation, location.Bytes())
+
+	return id
+}
+
+// the PC comes first followed by the return addresses, the
+// caller's location is the jal 8 bytes before where it returns to
+func (builder *profileBuilder) addSample(addresses []uint32) {
+	var locations []uint64 = nil
+	var key strings.Builder
+
+	for index, address := range addresses {
+		offset, ok := builder.lookup.offsetOf(address, builder.base)
+
+		if index > 0 {
+			offset, ok = builder.lookup.offsetOf(address-8, builder.base)
+		}
+
+		if !ok {
+			break
+		}
+
+		var id = builder.locationId(offset)
+		locations = append(locations, id)
+		key.WriteString(strconv.FormatUint(id, 16))
+		key.WriteByte(',')
+	}
+
+	if len(locations) == 0 {
+		return
+	}
+
+	var sample = builder.sampleIds[key.String()]
+
+	if sample == nil {
+		sample = &profileSampleEntry{locations, 0}
+		builder.sampleIds[key.String()] = sample
+		builder.samples = append(builder.samples, sample)
+	}
+
+	sample.count++
+}
+
+func (builder *profileBuilder) readSamples(input io.Reader) error {
+	var scanner = bufio.NewScanner(input)
+
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var addresses []uint32 = nil
+
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			value, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(field, "0x"), "0X"), 16, 32)
+
+			if err != nil {
+				return errors.New("Invalid address " + field + " in sample " + line)
+			}
+
+			addresses = append(addresses, uint32(value))
+		}
+
+		builder.addSample(addresses)
+	}
+
+	return scanner.Err()
+}
+
+func (builder *profileBuilder) write(filename string) []byte {
+	var profile protoBuffer
+	var valueType protoBuffer
+
+	valueType.uintField(valueTypeType, builder.stringId("samples"))
+	valueType.uintField(valueTypeUnit, builder.stringId("count"))
+	profile.bytesField(profileSampleType, valueType.Bytes())
+
+	for _, sample := range builder.samples {
+		var entry protoBuffer
+		entry.packedField(sampleLocationId, sample.locations)
+		entry.packedField(sampleValue, []uint64{sample.count})
+		profile.bytesField(profileSample, entry.Bytes())
+	}
+
+	var mapping protoBuffer
+	mapping.uintField(mappingId, 1)
+	mapping.uintField(mappingMemoryStart, uint64(builder.base))
+	mapping.uintField(mappingMemoryLimit, uint64(builder.base+builder.lookup.size))
+	mapping.uintField(mappingFilename, builder.stringId(filename))
+	mapping.boolField(mappingHasFunctions, true)
+	mapping.boolField(mappingHasFilenames, true)
+	mapping.boolField(mappingHasLineNumbers, true)
+	mapping.boolField(mappingHasInlineFrames, len(builder.lookup.inlines) > 0)
+	profile.bytesField(profileMapping, mapping.Bytes())
+
+	profile.Write(builder.locations.Bytes())
+	profile.Write(builder.functions.Bytes())
+
+	profile.bytesField(profilePeriodType, valueType.Bytes())
+	profile.uintField(profilePeriod, 1)
+
+	// strings go last since the other tables add to them
+	for _, value := range builder.strings {
+		profile.bytesField(profileStringTable, []byte(value))
+	}
+
+	return profile.Bytes()
+}
+
+func runPprof(args []string) error {
+	parsed, err := parsePprofArgs(args)
+
+	if err != nil {
+		return err
+	}
+
+	lookup, err := parsed.symbolizer.load()
+
+	if err != nil {
+		return err
+	}
+
+	var builder = newProfileBuilder(lookup, parsed.symbolizer.base)
+
+	if len(parsed.samples) == 0 {
+		err = builder.readSamples(os.Stdin)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, samples := range parsed.samples {
+		file, err := os.Open(samples)
+
+		if err != nil {
+			return err
+		}
+
+		err = builder.readSamples(file)
+		file.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var compressed bytes.Buffer
+	var writer = gzip.NewWriter(&compressed)
+	writer.Write(builder.write(path.Base(parsed.symbolizer.input)))
+	err = writer.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(parsed.output, compressed.Bytes(), 0664)
+}
@@ -5,27 +5,110 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/lambertjamesd/rsp2dwarf/elf"
 )
 
+type inputArg struct {
+	filename string
+	name     string
+}
+
 type commandLineArgs struct {
-	output       string
-	input        string
-	compDir      string
-	name         string
-	includeDebug bool
+	output         string
+	debugOutput    string
+	inputs         []inputArg
+	compDir        string
+	name           string
+	toolchain      string
+	debugFormat    string
+	inputFormat    string
+	overlays       []string
+	header         string
+	taskFormat     string
+	textOffset     uint32
+	report         string
+	budget         string
+	callGraph      string
+	xref           string
+	headerStruct   bool
+	includeDebug   bool
+	includeBuildId bool
+	compression    elf.DebugCompression
+}
+
+// commands that take the place of the default conversion
+// when given as the first argument
+var subcommands = map[string]func(args []string) error{
+	extractCommand:   runExtract,
+	listingCommand:   runListing,
+	cyclesCommand:    runCycles,
+	addr2lineCommand: runAddr2line,
+	traceCommand:     runTrace,
+	coverageCommand:  runCoverage,
+	pprofCommand:     runPprof,
+}
+
+var compressionNames = map[string]elf.DebugCompression{
+	"none":     elf.DebugCompressionNone,
+	"zlib":     elf.DebugCompressionZlib,
+	"zlib-gnu": elf.DebugCompressionZlibGnu,
 }
 
 func parseCommandLineArgs() (*commandLineArgs, error) {
 	var result commandLineArgs
 
 	if len(os.Args) == 1 {
-		return nil, errors.New(`rsp2dwarf [-n name] [-o output] [-d comp_dir] [-g] input
+		return nil, errors.New(`rsp2dwarf [-n name] [-o output] [-d comp_dir] [-t toolchain] [-g] [-f format] [-s debug_output] [-b] [-z compression] [-i format] [-v overlay@address] [-H header] [-S] [-T task] [-l offset] [-r report] [-B budget] [-c callgraph] [-x xref] [name=]input...
 	-n    the name to use in the linker
-	-o    the output file
+	      with more than one input use name=input to name each one
+	-o    the output file, ending it with .a writes an archive
+	      with an object per input instead of a single object
 	-d    directory compilation was done in
-	-g    generate debug symbols`)
+	-t    toolchain profile to match, defaults to ` + defaultToolchain + `
+` + toolchainUsage() + `
+	-g    generate debug symbols
+	-f    debug format, either ` + debugFormatDwarf + ` or ` + debugFormatMDebug + ` (ecoff)
+	-s    write debug symbols to a separate file linked with .gnu_debuglink
+	-b    add a .note.gnu.build-id to both split files
+	-z    compress debug sections, either none, zlib or zlib-gnu (.zdebug)
+	-i    assembler that produced the input, ` + autoFrontend + ` or one of ` + strings.Join(frontendNames(), ", ") + `
+	      defaults to ` + autoFrontend + `, which checks the files next to input
+	-v    code loaded over part of IMEM at address while the microcode runs
+	      may be repeated, each overlay gets its own section
+	-H    write a C header with the DMEM label offsets and sizes
+	-S    add a struct describing the DMEM layout to the header
+	-T    also write a <name>Task pointing at the microcode, either
+	      ` + taskFormatLibultra + ` for an OSTask or ` + taskFormatLibdragon + ` for an rsp_ucode_t
+	-l    IMEM offset the text is loaded at, defaults to 0, use 0x80 when loaded after rspboot
+	-r    write the IMEM and DMEM used by each microcode and symbol, - for standard out
+	-B    fail if a microcode uses more than allowed by a json budget file
+	-c    write the calls between routines as graphviz, or json if the name ends in .json
+	-x    write which routines read and write each data label, as json if the name ends in .json
+
+rsp2dwarf extract [-o output] input
+	writes the IMEM and DMEM images, .dbg and .sym files back out of an object
+
+rsp2dwarf listing [-o output] [-f text|html] [-i assembler] input
+	disassembles IMEM with labels and the source lines interleaved
+
+rsp2dwarf cycles [-i assembler] input
+	estimates the cycles of each basic block and routine along with the stalls
+
+rsp2dwarf addr2line [-b base] [-j section] [-i assembler] input [address...]
+	looks up the routine and source line of RSP addresses
+
+rsp2dwarf trace [-p pattern] [-b base] [-j section] [-i assembler] input [trace]
+	replaces the PCs in an emulator trace with routines and source lines
+
+rsp2dwarf coverage [-o output.info] [-H directory] [-p pattern] [-b base] [-j section] [-i assembler] input [trace...]
+	writes the line and routine coverage of traces as lcov or html
+
+rsp2dwarf pprof [-o profile.pb.gz] [-b base] [-j section] [-i assembler] input [samples...]
+	converts sampled PCs into a profile for go tool pprof
+
+run a command without arguments for the details of its flags`)
 	}
 
 	for i := 1; i < len(os.Args); i++ {
@@ -53,29 +136,184 @@ func parseCommandLineArgs() (*commandLineArgs, error) {
 					result.compDir = os.Args[i+1]
 					i++
 				}
+			} else if arg == "-t" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-t flag requires a parameter")
+				} else {
+					result.toolchain = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-f" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-f flag requires a parameter")
+				} else {
+					result.debugFormat = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-s" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-s flag requires a parameter")
+				} else {
+					result.debugOutput = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-b" {
+				result.includeBuildId = true
+			} else if arg == "-z" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-z flag requires a parameter")
+				}
+
+				compression, ok := compressionNames[os.Args[i+1]]
+
+				if !ok {
+					return nil, errors.New("-z must be none, zlib or zlib-gnu")
+				}
+
+				result.compression = compression
+				i++
+			} else if arg == "-i" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-i flag requires a parameter")
+				} else {
+					result.inputFormat = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-v" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-v flag requires a parameter")
+				} else {
+					result.overlays = append(result.overlays, os.Args[i+1])
+					i++
+				}
+			} else if arg == "-H" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-H flag requires a parameter")
+				} else {
+					result.header = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-T" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-T flag requires a parameter")
+				}
+
+				if os.Args[i+1] != taskFormatLibultra && os.Args[i+1] != taskFormatLibdragon {
+					return nil, errors.New("-T must be either " + taskFormatLibultra + " or " + taskFormatLibdragon)
+				}
+
+				result.taskFormat = os.Args[i+1]
+				i++
+			} else if arg == "-l" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-l flag requires a parameter")
+				}
+
+				offset, err := parseMaybeHex(os.Args[i+1], 32)
+
+				if err != nil || offset < 0 || offset >= imemSize || offset%dmaAlignment != 0 {
+					return nil, errors.New("-l must be a multiple of 8 inside IMEM, such as 0x80")
+				}
+
+				result.textOffset = uint32(offset)
+				i++
+			} else if arg == "-r" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-r flag requires a parameter")
+				} else {
+					result.report = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-B" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-B flag requires a parameter")
+				} else {
+					result.budget = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-c" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-c flag requires a parameter")
+				} else {
+					result.callGraph = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-x" {
+				if i+1 >= len(os.Args) {
+					return nil, errors.New("-x flag requires a parameter")
+				} else {
+					result.xref = os.Args[i+1]
+					i++
+				}
+			} else if arg == "-S" {
+				result.headerStruct = true
 			} else if arg == "-g" {
 				result.includeDebug = true
 			}
 
 		} else {
-			if result.input != "" {
-				return nil, errors.New("Only one input file is allowed")
-			} else {
-				result.input = arg
-			}
+			result.inputs = append(result.inputs, parseInputArg(arg))
 		}
 	}
 
-	if result.input == "" {
+	if len(result.inputs) == 0 {
 		return nil, errors.New("An input file is required")
 	}
 
-	if result.name == "" {
-		result.name = linkNameFromFileName(result.input)
+	if result.name != "" {
+		if len(result.inputs) > 1 {
+			return nil, errors.New("-n can only be used with a single input, use name=input instead")
+		}
+
+		result.inputs[0].name = result.name
+	}
+
+	var names = make(map[string]bool)
+
+	for _, input := range result.inputs {
+		if names[input.name] {
+			return nil, errors.New("The link name " + input.name + " is used by more than one input")
+		}
+
+		names[input.name] = true
 	}
 
 	if result.output == "" {
-		result.output = result.input + ".o"
+		result.output = result.inputs[0].filename + ".o"
+	}
+
+	if len(result.inputs) > 1 && len(result.overlays) > 0 {
+		return nil, errors.New("Overlays can only be used with a single input")
+	}
+
+	if result.headerStruct && result.header == "" {
+		return nil, errors.New("-S requires a header output set with -H")
+	}
+
+	if isArchiveOutput(result.output) && result.debugOutput != "" {
+		return nil, errors.New("-s can't be used when writing an archive")
+	}
+
+	if result.includeBuildId && result.debugOutput == "" {
+		return nil, errors.New("-b requires a split debug output set with -s")
+	}
+
+	if result.inputFormat == "" {
+		result.inputFormat = autoFrontend
+	}
+
+	if result.toolchain == "" {
+		result.toolchain = defaultToolchain
+	}
+
+	if result.debugFormat == "" {
+		result.debugFormat = debugFormatDwarf
+	} else if result.debugFormat != debugFormatDwarf && result.debugFormat != debugFormatMDebug {
+		return nil, errors.New("-f must be either " + debugFormatDwarf + " or " + debugFormatMDebug)
+	}
+
+	if result.debugFormat == debugFormatMDebug && len(result.overlays) > 0 {
+		return nil, errors.New("Overlays are only supported with " + debugFormatDwarf + " debug info")
 	}
 
 	if result.compDir == "" {
@@ -91,6 +329,17 @@ func parseCommandLineArgs() (*commandLineArgs, error) {
 	return &result, nil
 }
 
+// input or name=input
+func parseInputArg(arg string) inputArg {
+	var equals = strings.Index(arg, "=")
+
+	if equals > 0 {
+		return inputArg{arg[equals+1:], arg[:equals]}
+	}
+
+	return inputArg{arg, linkNameFromFileName(arg)}
+}
+
 func linkNameFromFileName(input string) string {
 	input = path.Base(input)
 	ext := path.Ext(input)
@@ -119,6 +368,21 @@ func linkNameFromFileName(input string) string {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		command, ok := subcommands[os.Args[1]]
+
+		if ok {
+			err := command(os.Args[2:])
+
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			return
+		}
+	}
+
 	args, err := parseCommandLineArgs()
 
 	if err != nil {
@@ -126,21 +390,136 @@ func main() {
 		os.Exit(1)
 	}
 
-	elfFile, err := buildElf(args.input, args.name, args.compDir, args.includeDebug)
+	profile, err := findToolchainProfile(args.toolchain)
 
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
 
-	outFile, err := os.OpenFile(args.output, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	// debug info is always needed when splitting while the header
+	// and reports only need the symbols, so they work without a .sym
+	var loadDebug = DebugNone
+
+	if args.includeDebug || args.debugOutput != "" {
+		loadDebug = DebugAll
+	} else if args.header != "" || args.report != "" || args.budget != "" || args.callGraph != "" || args.xref != "" {
+		loadDebug = DebugSymbols | DebugLinesIfPresent
+	}
+	var names []string = nil
+	var inputs []*MicrocodeInput = nil
+
+	for _, inputArg := range args.inputs {
+		frontend, err := findFrontend(args.inputFormat, inputArg.filename, loadDebug)
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		// symbols and lines are moved to be relative to the start of the text like overlays
+		input, err := frontend.Load(inputArg.filename, loadDebug, args.textOffset)
+
+		if err == nil {
+			err = readExternalReferences(inputArg.filename, input)
+		}
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		names = append(names, inputArg.name)
+		inputs = append(inputs, input)
+	}
+
+	err = loadOverlays(args.overlays, inputs[0], names[0], args.inputFormat, loadDebug)
 
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
 
-	defer outFile.Close()
+	var filenames []string = nil
+
+	for _, inputArg := range args.inputs {
+		filenames = append(filenames, inputArg.filename)
+	}
+
+	err = checkCapacity(filenames, inputs, args.textOffset)
 
-	elf.Serialize(outFile, elfFile)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	for index, input := range inputs {
+		reportWarnings(validateMicrocode(filenames[index], input, args.textOffset))
+	}
+
+	var microcodes = placeMicrocodes(names, inputs, args.taskFormat, args.textOffset)
+
+	if args.report != "" {
+		err = writeBudgetReport(args.report, microcodes, args.textOffset)
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if args.budget != "" {
+		err = checkBudget(args.budget, microcodes)
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if args.callGraph != "" {
+		err = writeCallGraph(args.callGraph, microcodes)
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if args.xref != "" {
+		err = writeDataXref(args.xref, microcodes)
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if args.header != "" {
+		err = writeHeader(args.header, microcodes, args.headerStruct)
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if isArchiveOutput(args.output) {
+		err = writeArchive(microcodes, args, profile)
+	} else if args.debugOutput != "" {
+		err = writeSplitDebug(microcodes, args, profile)
+	} else {
+		var elfFile *elf.ElfFile
+		elfFile, err = buildElf(microcodes, args.compDir, args.includeDebug, args.debugFormat, profile)
+
+		if err == nil {
+			elfFile.DebugCompression = args.compression
+			err = writeElfFile(args.output, elfFile)
+		}
+	}
+
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
 }
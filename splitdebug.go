@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+func writeElfFile(filename string, elfFile *elf.ElfFile) error {
+	outFile, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	defer outFile.Close()
+
+	return elf.Serialize(outFile, elfFile)
+}
+
+func isDebugSection(name string) bool {
+	return strings.HasPrefix(name, ".debug_") ||
+		strings.HasPrefix(name, ".rel.debug_") ||
+		name == ".mdebug"
+}
+
+// sections holding what gets loaded onto the RSP
+func isContentSection(name string) bool {
+	return name == ".text" || name == ".data" ||
+		strings.HasPrefix(name, ".text.") ||
+		strings.HasPrefix(name, ".data.") ||
+		name == ".ovly_table" ||
+		isOverlaySection(name)
+}
+
+func calculateBuildId(debugElf *elf.ElfFile) []byte {
+	var hash = sha1.New()
+
+	for _, section := range debugElf.Sections {
+		if isContentSection(section.Name) || isDebugSection(section.Name) {
+			hash.Write([]byte(section.Name))
+			hash.Write(section.Data)
+		}
+	}
+
+	return hash.Sum(nil)
+}
+
+func writeSplitDebug(microcodes []*Microcode, args *commandLineArgs, profile *toolchainProfile) error {
+	strippedElf, err := buildElf(microcodes, args.compDir, false, args.debugFormat, profile)
+
+	if err != nil {
+		return err
+	}
+
+	debugElf, err := buildElf(microcodes, args.compDir, true, args.debugFormat, profile)
+
+	if err != nil {
+		return err
+	}
+
+	if args.includeBuildId {
+		var buildId = calculateBuildId(debugElf)
+		strippedElf.Sections = append(strippedElf.Sections, elf.BuildBuildIdSection(buildId, binary.BigEndian))
+		debugElf.Sections = append(debugElf.Sections, elf.BuildBuildIdSection(buildId, binary.BigEndian))
+	}
+
+	// the debug file only describes the code, the
+	// contents live in the stripped object
+	for index := range debugElf.Sections {
+		var section = &debugElf.Sections[index]
+
+		if isContentSection(section.Name) {
+			section.Type = elf.SHT_NOBITS
+		}
+	}
+
+	debugElf.DebugCompression = args.compression
+
+	err = writeElfFile(args.debugOutput, debugElf)
+
+	if err != nil {
+		return err
+	}
+
+	debugData, err := ioutil.ReadFile(args.debugOutput)
+
+	if err != nil {
+		return err
+	}
+
+	strippedElf.Sections = append(strippedElf.Sections, elf.BuildDebugLinkSection(
+		path.Base(args.debugOutput),
+		crc32.ChecksumIEEE(debugData),
+		binary.BigEndian,
+	))
+
+	return writeElfFile(args.output, strippedElf)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+const taskFormatNone = ""
+const taskFormatLibultra = "libultra"
+const taskFormatLibdragon = "libdragon"
+
+// OSTask from libultra, the union forces 8 byte alignment
+const osTaskSize = 0x40
+const osTaskUcode = 0x10
+const osTaskUcodeSize = 0x14
+const osTaskUcodeData = 0x18
+const osTaskUcodeDataSize = 0x1C
+
+// rsp_ucode_t from libdragon
+const rspUcodeSize = 0x20
+const rspUcodeCode = 0x00
+const rspUcodeData = 0x04
+const rspUcodeCodeEnd = 0x08
+const rspUcodeDataEnd = 0x0C
+const rspUcodeName = 0x10
+
+func (microcode *Microcode) TaskSection() string {
+	return ".data." + microcode.Name + "Task"
+}
+
+func (microcode *Microcode) TaskName() string {
+	return microcode.Name + "Task"
+}
+
+// a REL relocation adds the symbol to the value already in place
+func writePointer(data []byte, relocations *elf.RelocationBuilder, offset uint32, section string, addend uint32) {
+	binary.BigEndian.PutUint32(data[offset:], addend)
+	relocations.AddEntry(offset, section, elf.R_MIPS_32)
+}
+
+func buildOSTask(microcode *Microcode) ([]byte, *elf.RelocationBuilder) {
+	var data = make([]byte, osTaskSize)
+	var relocations = elf.NewRelocationBuilder()
+
+	writePointer(data, relocations, osTaskUcode, microcode.TextSection, 0)
+	binary.BigEndian.PutUint32(data[osTaskUcodeSize:], uint32(len(microcode.Input.Text)))
+	writePointer(data, relocations, osTaskUcodeData, microcode.DataSection, 0)
+	binary.BigEndian.PutUint32(data[osTaskUcodeDataSize:], uint32(len(microcode.Input.Data)))
+
+	return data, relocations
+}
+
+func buildRspUcode(microcode *Microcode) ([]byte, *elf.RelocationBuilder) {
+	var result bytes.Buffer
+	var relocations = elf.NewRelocationBuilder()
+
+	result.Write(make([]byte, rspUcodeSize))
+	// the name pointed to by the struct follows it
+	result.WriteString(microcode.Name)
+	result.WriteByte(0)
+
+	var data = result.Bytes()
+
+	writePointer(data, relocations, rspUcodeCode, microcode.TextSection, 0)
+	writePointer(data, relocations, rspUcodeData, microcode.DataSection, 0)
+	writePointer(data, relocations, rspUcodeCodeEnd, microcode.TextSection, uint32(len(microcode.Input.Text)))
+	writePointer(data, relocations, rspUcodeDataEnd, microcode.DataSection, uint32(len(microcode.Input.Data)))
+	writePointer(data, relocations, rspUcodeName, microcode.TaskSection(), rspUcodeSize)
+
+	return data, relocations
+}
+
+func taskSize(format string) uint32 {
+	if format == taskFormatLibdragon {
+		return rspUcodeSize
+	}
+
+	return osTaskSize
+}
+
+func buildTask(microcode *Microcode) ([]byte, *elf.RelocationBuilder) {
+	if microcode.TaskFormat == taskFormatLibdragon {
+		return buildRspUcode(microcode)
+	}
+
+	return buildOSTask(microcode)
+}
+
+func buildTaskSection(name string, data []byte) elf.ElfSection {
+	return elf.BuildElfSection(
+		name,
+		elf.SHT_PROGBITS,
+		elf.SHF_WRITE|elf.SHF_ALLOC,
+		0,
+		0,
+		0,
+		8,
+		0,
+		data,
+	)
+}
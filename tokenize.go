@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+func isSpace(character byte) bool {
+	return character == ' ' || character == '\t' || character == '\r' || character == '\v' || character == '\f'
+}
+
+// splits a line on whitespace, text surrounded in double quotes
+// is kept as a single token and may contain \" or \\ escapes
+func tokenizeLine(line string) ([]string, error) {
+	var result []string = nil
+	var index = 0
+
+	for index < len(line) {
+		for index < len(line) && isSpace(line[index]) {
+			index++
+		}
+
+		if index == len(line) {
+			break
+		}
+
+		if line[index] == '"' {
+			var token strings.Builder
+			var closed = false
+			index++
+
+			for index < len(line) {
+				// other backslashes are kept so paths such as C:\src\gfx.s survive
+				if line[index] == '\\' && index+1 < len(line) && (line[index+1] == '"' || line[index+1] == '\\') {
+					token.WriteByte(line[index+1])
+					index += 2
+				} else if line[index] == '"' {
+					closed = true
+					index++
+					break
+				} else {
+					token.WriteByte(line[index])
+					index++
+				}
+			}
+
+			if !closed {
+				return nil, errors.New("unterminated quoted string")
+			}
+
+			result = append(result, token.String())
+		} else {
+			var start = index
+
+			for index < len(line) && !isSpace(line[index]) {
+				index++
+			}
+
+			result = append(result, line[start:index])
+		}
+	}
+
+	return result, nil
+}
+
+func splitLines(input string) []string {
+	return strings.Split(strings.ReplaceAll(input, "\r\n", "\n"), "\n")
+}
+
+// quotes a token so tokenizeLine reads it back unchanged
+func quoteToken(token string) string {
+	if token != "" && !strings.ContainsAny(token, " \t\r\v\f\"\\") {
+		return token
+	}
+
+	var result strings.Builder
+
+	result.WriteByte('"')
+
+	for index := 0; index < len(token); index++ {
+		if token[index] == '"' || token[index] == '\\' {
+			result.WriteByte('\\')
+		}
+
+		result.WriteByte(token[index])
+	}
+
+	result.WriteByte('"')
+
+	return result.String()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/elf"
+)
+
+type toolchainProfile struct {
+	name             string
+	description      string
+	flags            uint32
+	debugSectionType elf.SectionType
+	dwarfVersion     uint16
+	includeRegInfo   bool
+	includeAbiFlags  bool
+	isaLevel         uint8
+	gprSize          uint8
+}
+
+const defaultToolchain = "default"
+
+var toolchainProfiles = map[string]*toolchainProfile{
+	defaultToolchain: {
+		name:             defaultToolchain,
+		description:      "mips3 o32, matches previous rsp2dwarf output",
+		flags:            elf.EF_MIPS_ARCH_3 | elf.EF_MIPS_32BITMODE | elf.EF_MIPS_NOREORDER,
+		debugSectionType: elf.SHT_MIPS_DWARF,
+		dwarfVersion:     2,
+	},
+	"ido5.3": {
+		name:             "ido5.3",
+		description:      "SGI IDO 5.3 -mips2 -non_shared",
+		flags:            elf.EF_MIPS_ARCH_2 | elf.EF_MIPS_NOREORDER,
+		debugSectionType: elf.SHT_MIPS_DWARF,
+		dwarfVersion:     2,
+		includeRegInfo:   true,
+	},
+	"ido7.1": {
+		name:             "ido7.1",
+		description:      "SGI IDO 7.1 -mips2 -32 -non_shared",
+		flags:            elf.EF_MIPS_ARCH_2 | elf.EF_MIPS_NOREORDER,
+		debugSectionType: elf.SHT_MIPS_DWARF,
+		dwarfVersion:     2,
+		includeRegInfo:   true,
+	},
+	"gcc2.7": {
+		name:             "gcc2.7",
+		description:      "libultra modular gcc 2.7.2 o32",
+		flags:            elf.EF_MIPS_ARCH_2 | elf.EF_MIPS_NOREORDER,
+		debugSectionType: elf.SHT_MIPS_DWARF,
+		dwarfVersion:     2,
+		includeRegInfo:   true,
+	},
+	"libdragon": {
+		name:             "libdragon",
+		description:      "mips64-elf gcc -march=vr4300 -mabi=o64",
+		flags:            elf.EF_MIPS_ARCH_3 | elf.EF_MIPS_ABI_O64 | elf.EF_MIPS_NOREORDER,
+		debugSectionType: elf.SHT_MIPS_DWARF,
+		dwarfVersion:     4,
+		includeRegInfo:   true,
+		includeAbiFlags:  true,
+		isaLevel:         3,
+		gprSize:          elf.AFL_REG_64,
+	},
+	"mips64-n32": {
+		name:             "mips64-n32",
+		description:      "mips64-elf gcc -march=vr4300 -mabi=n32",
+		flags:            elf.EF_MIPS_ARCH_3 | elf.EF_MIPS_ABI2 | elf.EF_MIPS_NOREORDER,
+		debugSectionType: elf.SHT_MIPS_DWARF,
+		dwarfVersion:     4,
+		includeAbiFlags:  true,
+		isaLevel:         3,
+		gprSize:          elf.AFL_REG_64,
+	},
+	"generic": {
+		name:             "generic",
+		description:      "mips3 o32 with PROGBITS debug sections for non-mips readers",
+		flags:            elf.EF_MIPS_ARCH_3 | elf.EF_MIPS_32BITMODE | elf.EF_MIPS_NOREORDER,
+		debugSectionType: elf.SHT_PROGBITS,
+		dwarfVersion:     4,
+	},
+}
+
+func findToolchainProfile(name string) (*toolchainProfile, error) {
+	profile, ok := toolchainProfiles[name]
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown toolchain '%s', expected one of %s", name, strings.Join(toolchainNames(), ", "))
+	}
+
+	return profile, nil
+}
+
+func toolchainNames() []string {
+	var result []string = nil
+
+	for name := range toolchainProfiles {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func toolchainUsage() string {
+	var result []string = nil
+
+	for _, name := range toolchainNames() {
+		result = append(result, fmt.Sprintf("\t    %-12s %s", name, toolchainProfiles[name].description))
+	}
+
+	return strings.Join(result, "\n")
+}
+
+func (profile *toolchainProfile) debugSection(name string, entrySize uint32, data []byte) elf.ElfSection {
+	return elf.BuildElfSection(
+		name,
+		profile.debugSectionType,
+		0,
+		0,
+		0,
+		0,
+		1,
+		entrySize,
+		data,
+	)
+}
+
+func (profile *toolchainProfile) appendMipsSections(elfFile *elf.ElfFile, byteOrder binary.ByteOrder) {
+	if profile.includeRegInfo {
+		elfFile.Sections = append(elfFile.Sections, elf.BuildRegInfoSection(byteOrder))
+	}
+
+	if profile.includeAbiFlags {
+		elfFile.Sections = append(elfFile.Sections, elf.BuildAbiFlagsSection(
+			profile.isaLevel,
+			profile.gprSize,
+			elf.Val_GNU_MIPS_ABI_FP_ANY,
+			byteOrder,
+		))
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const traceCommand = "trace"
+
+// a hex number at the start of the line, optionally after a prefix such as rsp:
+// it needs a digit so words like add at the start of a line aren't taken as the PC
+const defaultTracePattern = `^\s*(?:\w+:\s*)?(?:0x)?(?P<pc>[0-9A-Fa-f]*[0-9][0-9A-Fa-f]*)\b`
+
+// the longest loop body that gets collapsed, which also
+// bounds how much of the trace is kept in memory
+const maxTraceLoop = 64
+
+type traceArgs struct {
+	symbolizer *symbolizerArgs
+	trace      string
+	pattern    string
+}
+
+func parseTraceArgs(args []string) (*traceArgs, error) {
+	var result = traceArgs{nil, "", defaultTracePattern}
+
+	if len(args) == 0 {
+		return nil, errors.New(`rsp2dwarf trace [-p pattern] [-b base] [-j section] [-i assembler] input [trace]
+	-p    regular expression finding the PC in each line, either in a group named pc or the first group
+	      defaults to a hex number at the start of the line
+` + symbolizerUsage() + `
+	the trace is read from standard in when it isn't given`)
+	}
+
+	symbolizer, rest, err := parseSymbolizerArgs(args, func(flag string, value string) (bool, error) {
+		if flag == "-p" {
+			result.pattern = value
+			return true, nil
+		}
+
+		return false, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 1 {
+		return nil, errors.New("Only one trace file is allowed")
+	} else if len(rest) == 1 {
+		result.trace = rest[0]
+	}
+
+	result.symbolizer = symbolizer
+	return &result, nil
+}
+
+// finds the PC in a line of a trace along with where it is in the line
+type traceParser interface {
+	ParsePC(line string) (pc uint32, start int, end int, ok bool)
+}
+
+type regexTraceParser struct {
+	pattern *regexp.Regexp
+	group   int
+}
+
+func newRegexTraceParser(pattern string) (*regexTraceParser, error) {
+	compiled, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return nil, errors.New("Invalid trace pattern: " + err.Error())
+	}
+
+	var group = 0
+
+	if compiled.NumSubexp() > 0 {
+		group = 1
+	}
+
+	for index, name := range compiled.SubexpNames() {
+		if name == "pc" {
+			group = index
+		}
+	}
+
+	return &regexTraceParser{compiled, group}, nil
+}
+
+func (parser *regexTraceParser) ParsePC(line string) (uint32, int, int, bool) {
+	var match = parser.pattern.FindStringSubmatchIndex(line)
+
+	if match == nil || match[parser.group*2] < 0 {
+		return 0, 0, 0, false
+	}
+
+	var start = match[parser.group*2]
+	var end = match[parser.group*2+1]
+	var text = strings.TrimPrefix(strings.TrimPrefix(line[start:end], "0x"), "0X")
+
+	// the 0x is replaced along with the number
+	if start >= 2 && strings.EqualFold(line[start-2:start], "0x") {
+		start -= 2
+	}
+
+	pc, err := strconv.ParseUint(text, 16, 32)
+
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	return uint32(pc), start, end, true
+}
+
+// writes lines through, replacing the second and later times
+// round a loop with a summary once the loop ends
+type loopCollapser struct {
+	output   *bufio.Writer
+	describe func(pc uint32) string
+	// the most recent PCs written out, oldest first
+	history []uint32
+	// the loop being matched against, nil when not in a loop
+	body    []uint32
+	matched int
+	repeats int
+	// lines of the current time round the loop
+	pending []string
+}
+
+func (collapser *loopCollapser) remember(pc uint32) {
+	if len(collapser.history) == maxTraceLoop {
+		collapser.history = append(collapser.history[:0], collapser.history[1:]...)
+	}
+
+	collapser.history = append(collapser.history, pc)
+}
+
+func (collapser *loopCollapser) endLoop() {
+	if collapser.body == nil {
+		return
+	}
+
+	if collapser.repeats > 0 {
+		fmt.Fprintf(collapser.output, "... %d instruction loop at %s repeated %d more times\n", len(collapser.body), collapser.describe(collapser.body[0]), collapser.repeats)
+	}
+
+	for index, line := range collapser.pending {
+		collapser.output.WriteString(line)
+		collapser.output.WriteByte('\n')
+		collapser.remember(collapser.body[index])
+	}
+
+	collapser.body = nil
+	collapser.pending = collapser.pending[:0]
+}
+
+func (collapser *loopCollapser) add(pc uint32, line string) {
+	if collapser.body != nil {
+		if collapser.body[collapser.matched] == pc {
+			collapser.pending = append(collapser.pending, line)
+			collapser.matched++
+
+			if collapser.matched == len(collapser.body) {
+				collapser.repeats++
+				collapser.matched = 0
+				collapser.pending = collapser.pending[:0]
+			}
+
+			return
+		}
+
+		collapser.endLoop()
+	}
+
+	// the most recent time the PC was seen is the start of a possible loop
+	for distance := 1; distance <= len(collapser.history); distance++ {
+		if collapser.history[len(collapser.history)-distance] == pc {
+			collapser.body = append([]uint32(nil), collapser.history[len(collapser.history)-distance:]...)
+			collapser.matched = 0
+			collapser.repeats = 0
+			collapser.add(pc, line)
+			return
+		}
+	}
+
+	collapser.output.WriteString(line)
+	collapser.output.WriteByte('\n')
+	collapser.remember(pc)
+}
+
+// lines without a PC end any loop
+func (collapser *loopCollapser) addOther(line string) {
+	collapser.endLoop()
+	collapser.history = collapser.history[:0]
+	collapser.output.WriteString(line)
+	collapser.output.WriteByte('\n')
+}
+
+func symbolizeTrace(input io.Reader, output io.Writer, parser traceParser, lookup *symbolizer, base uint32) error {
+	var writer = bufio.NewWriter(output)
+	var scanner = bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var describe = func(pc uint32) string {
+		offset, ok := lookup.offsetOf(pc, base)
+
+		if !ok {
+			return fmt.Sprintf("0x%08X", pc)
+		}
+
+		var frame = lookup.frames(offset)[0]
+		return fmt.Sprintf("%s (%s:%d)", lookup.function(offset), frame.filename, frame.line)
+	}
+
+	var collapser = &loopCollapser{writer, describe, nil, nil, 0, 0, nil}
+
+	for scanner.Scan() {
+		var line = scanner.Text()
+		pc, start, end, ok := parser.ParsePC(line)
+
+		if !ok {
+			collapser.addOther(line)
+			continue
+		}
+
+		collapser.add(pc, line[:start]+describe(pc)+line[end:])
+	}
+
+	collapser.endLoop()
+
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+
+	return writer.Flush()
+}
+
+func runTrace(args []string) error {
+	parsed, err := parseTraceArgs(args)
+
+	if err != nil {
+		return err
+	}
+
+	parser, err := newRegexTraceParser(parsed.pattern)
+
+	if err != nil {
+		return err
+	}
+
+	lookup, err := parsed.symbolizer.load()
+
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+
+	if parsed.trace != "" {
+		file, err := os.Open(parsed.trace)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		input = file
+	}
+
+	return symbolizeTrace(input, os.Stdout, parser, lookup, parsed.symbolizer.base)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/lambertjamesd/rsp2dwarf/disasm"
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+// a block of code in IMEM, start and end are offsets from the start of IMEM
+type imemRange struct {
+	start uint32
+	end   uint32
+}
+
+func (r imemRange) contains(offset uint32) bool {
+	return offset >= r.start && offset < r.end
+}
+
+type sortLinesByAddress []dwarf.InstructionEntry
+
+func (arr sortLinesByAddress) Len() int {
+	return len(arr)
+}
+
+func (arr sortLinesByAddress) Less(i, j int) bool {
+	return arr[i].Address() < arr[j].Address()
+}
+
+func (arr sortLinesByAddress) Swap(i, j int) {
+	arr[i], arr[j] = arr[j], arr[i]
+}
+
+// reports problems at the source line the instruction came from
+// falling back to the input when there is no line info
+type textLocator struct {
+	filename string
+	lines    sortLinesByAddress
+}
+
+func newTextLocator(filename string, lines []dwarf.InstructionEntry) *textLocator {
+	var sorted = append(sortLinesByAddress(nil), lines...)
+	sort.Stable(sorted)
+	return &textLocator{filename, sorted}
+}
+
+// the file and line of the instruction, line is 0 if it isn't known
+func (locator *textLocator) location(offset int) (string, int) {
+	var index = sort.Search(len(locator.lines), func(i int) bool {
+		return locator.lines[i].Address() > offset
+	}) - 1
+
+	if index < 0 {
+		return locator.filename, 0
+	}
+
+	return locator.lines[index].Filename(), locator.lines[index].Line()
+}
+
+func (locator *textLocator) diagnostic(offset int, format string, args ...interface{}) diagnostic {
+	filename, line := locator.location(offset)
+	return createDiagnostic(filename, line, format, args...)
+}
+
+// the program counter is 12 bits so a target that only lands in IMEM
+// after wrapping usually means the code was assembled at the wrong address
+func wrapsAroundImem(instruction *disasm.Instruction) bool {
+	if instruction.Is(disasm.FlagJump) {
+		var target = (instruction.Word & 0x3FFFFFF) << 2
+		_, isText, ok := classifyRspAddress(target)
+		return target >= imemSize && !(ok && isText)
+	}
+
+	var offset = int64(instruction.Address-imemAddress) + 4 + int64(int16(instruction.Word&0xFFFF))*4
+	return offset < 0 || offset >= imemSize
+}
+
+func validateText(filename string, input *MicrocodeInput, loadAddress uint32, validTargets []imemRange) []diagnostic {
+	var result []diagnostic = nil
+	var locator = newTextLocator(filename, input.Lines)
+	var text = input.Text
+	var address = imemAddress + loadAddress
+
+	// targets can only be checked against labels if there are any
+	var labelled = make(map[int]bool)
+
+	for _, line := range input.Lines {
+		labelled[line.Address()] = true
+	}
+
+	if input.Symbols != nil {
+		for _, symbol := range input.Symbols.Instructions {
+			labelled[int(symbol.Value)] = true
+		}
+	}
+
+	var previousHasDelaySlot = false
+
+	for index, instruction := range disasm.DecodeText(text, address) {
+		var offset = index * 4
+
+		if instruction.Is(disasm.FlagInvalid) {
+			result = append(result, locator.diagnostic(offset, "0x%08X at 0x%08X is not a valid instruction", instruction.Word, instruction.Address))
+		}
+
+		if previousHasDelaySlot && instruction.HasDelaySlot() {
+			result = append(result, locator.diagnostic(offset, "%s at 0x%08X is in the delay slot of another branch", instruction.Mnemonic, instruction.Address))
+		}
+
+		previousHasDelaySlot = instruction.HasDelaySlot()
+
+		if !instruction.HasTarget() {
+			continue
+		}
+
+		var target = instruction.Target - imemAddress
+		var inRange = false
+
+		for _, valid := range validTargets {
+			if valid.contains(target) {
+				inRange = true
+				break
+			}
+		}
+
+		if wrapsAroundImem(&instruction) {
+			result = append(result, locator.diagnostic(offset, "%s at 0x%08X goes outside of IMEM and wraps around to 0x%08X", instruction.Mnemonic, instruction.Address, instruction.Target))
+		} else if !inRange {
+			result = append(result, locator.diagnostic(offset, "%s at 0x%08X goes to 0x%08X outside of the code", instruction.Mnemonic, instruction.Address, instruction.Target))
+		} else if len(labelled) > 0 && target >= loadAddress && target < loadAddress+uint32(len(text)) && !labelled[int(target-loadAddress)] {
+			result = append(result, locator.diagnostic(offset, "%s at 0x%08X goes to 0x%08X which has no label or line info", instruction.Mnemonic, instruction.Address, instruction.Target))
+		}
+	}
+
+	return result
+}
+
+// checks the microcode and its overlays, which can jump to each other
+func validateMicrocode(filename string, input *MicrocodeInput, loadAddress uint32) []diagnostic {
+	var mainRange = imemRange{loadAddress, loadAddress + uint32(len(input.Text))}
+	var result = validateText(filename, input, loadAddress, append([]imemRange{mainRange}, overlayRanges(input.Overlays)...))
+
+	for _, overlay := range input.Overlays {
+		var ownRange = imemRange{overlay.LoadAddress, overlay.LoadAddress + uint32(len(overlay.Input.Text))}
+		result = append(result, validateText(overlay.Name, overlay.Input, overlay.LoadAddress, []imemRange{ownRange, mainRange})...)
+	}
+
+	return result
+}
+
+func overlayRanges(overlays []*Overlay) []imemRange {
+	var result []imemRange = nil
+
+	for _, overlay := range overlays {
+		result = append(result, imemRange{overlay.LoadAddress, overlay.LoadAddress + uint32(len(overlay.Input.Text))})
+	}
+
+	return result
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/lambertjamesd/rsp2dwarf/disasm"
+	"github.com/lambertjamesd/rsp2dwarf/dwarf"
+)
+
+const accessRead = "read"
+const accessWrite = "write"
+
+type dataReference struct {
+	// empty when the instruction isn't in a routine
+	Routine string `json:"routine,omitempty"`
+	Symbol  string `json:"symbol"`
+	// bytes from the start of the symbol
+	Offset uint32 `json:"offset"`
+	// offset from the start of IMEM of the load or store
+	Address uint32 `json:"address"`
+	Access  string `json:"access"`
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// the data labels used by a microcode or one of its overlays
+type dataXref struct {
+	Name       string          `json:"name"`
+	OverlayOf  string          `json:"overlayOf,omitempty"`
+	References []dataReference `json:"references"`
+}
+
+// the innermost data symbol containing a DMEM offset
+func findDataSymbol(symbols *SymbolTable, offset uint32) *SymbolDef {
+	var result *SymbolDef = nil
+
+	for index := range symbols.Data {
+		var symbol = &symbols.Data[index]
+		var size = symbol.Size
+
+		if size == 0 {
+			size = 1
+		}
+
+		if symbol.AliasOf != "" || offset < symbol.Value || offset >= symbol.Value+size {
+			continue
+		}
+
+		if result == nil || symbol.Value > result.Value {
+			result = symbol
+		}
+	}
+
+	return result
+}
+
+// the value an instruction leaves in its destination when
+// its inputs are known, such as lui, ori and addiu
+func constantResult(instruction *disasm.Instruction, known map[disasm.Register]uint32) (uint32, bool) {
+	var word = instruction.Word
+	var rs = disasm.Register((word >> 21) & 0x1F)
+	var immediate = word & 0xFFFF
+	var base, ok = known[rs]
+
+	if rs == 0 {
+		base, ok = 0, true
+	}
+
+	switch word >> 26 {
+	case 0x0F:
+		return immediate << 16, true
+	case 0x08, 0x09:
+		return base + uint32(int32(int16(immediate))), ok
+	case 0x0C:
+		return base & immediate, ok
+	case 0x0D:
+		return base | immediate, ok
+	case 0x0E:
+		return base ^ immediate, ok
+	}
+
+	return 0, false
+}
+
+// follows constants through registers inside each basic block to
+// find the loads and stores with a known DMEM address
+func findDataReferences(filename string, input *MicrocodeInput, loadAddress uint32, data *SymbolTable) []dataReference {
+	var result []dataReference = nil
+
+	if data == nil {
+		return nil
+	}
+
+	var instructions = disasm.DecodeText(input.Text, imemAddress+loadAddress)
+
+	var routines = &callGraph{"", "", loadAddress, findRoutines(input, loadAddress), nil}
+	var starts = findBlockStarts(instructions, routines.Routines, loadAddress)
+	var locator = newTextLocator(filename, input.Lines)
+	var known = make(map[disasm.Register]uint32)
+
+	for index := range instructions {
+		var instruction = &instructions[index]
+		var offset = index * 4
+
+		if starts[offset] {
+			known = make(map[disasm.Register]uint32)
+		}
+
+		base, immediate, isMemory := instruction.MemoryOperand()
+		address, isKnown := known[base]
+
+		if base == 0 {
+			address, isKnown = 0, true
+		}
+
+		if isMemory && isKnown {
+			// DMEM is 4K and the address wraps around
+			var dmemOffset = (address + uint32(immediate)) & (dmemSize - 1)
+			var symbol = findDataSymbol(data, dmemOffset)
+
+			if symbol != nil {
+				var reference = dataReference{"", symbol.Name, dmemOffset - symbol.Value, loadAddress + uint32(offset), accessRead, "", 0}
+				var routine = routines.routineAt(reference.Address)
+
+				if routine != nil {
+					reference.Routine = routine.Name
+				}
+
+				if instruction.Is(disasm.FlagStore) {
+					reference.Access = accessWrite
+				}
+
+				reference.File, reference.Line = locator.location(offset)
+				result = append(result, reference)
+			}
+		}
+
+		var _, writes = instruction.Registers()
+		value, isConstant := constantResult(instruction, known)
+
+		for _, register := range writes {
+			if isConstant {
+				known[register] = value
+			} else {
+				delete(known, register)
+			}
+		}
+	}
+
+	return result
+}
+
+// overlays use the data of the microcode they are loaded over
+func buildDataXrefs(microcode *Microcode) []*dataXref {
+	var input = microcode.Input
+	var result = []*dataXref{{microcode.Name, "", findDataReferences(microcode.Name, input, microcode.LoadAddress, input.Symbols)}}
+
+	for _, overlay := range input.Overlays {
+		result = append(result, &dataXref{overlay.Name, microcode.Name, findDataReferences(overlay.Name, overlay.Input, overlay.LoadAddress, input.Symbols)})
+	}
+
+	return result
+}
+
+// a variable for each data label with a child of each routine
+// importing the variables it uses so debuggers can find them
+func buildDataVariables(symbols *SymbolTable, section string, version uint16) ([]*dwarf.AbbrevTreeNode, map[string]*dwarf.AbbrevTreeNode) {
+	var result []*dwarf.AbbrevTreeNode = nil
+	var byName = make(map[string]*dwarf.AbbrevTreeNode)
+
+	if symbols == nil {
+		return nil, byName
+	}
+
+	for _, symbol := range symbols.Data {
+		if symbol.AliasOf != "" || symbol.Kind == SymbolLocalLabel {
+			continue
+		}
+
+		var node = &dwarf.AbbrevTreeNode{
+			Tag: dwarf.DW_TAG_variable,
+			Attributes: []dwarf.AbbrevAttr{
+				dwarf.CreateStringAttr(dwarf.DW_AT_name, symbol.Name, false),
+				dwarf.CreateSectionLocationAttr(dwarf.DW_AT_location, section, int64(symbol.Value), version),
+				dwarf.CreateFlagAttr(dwarf.DW_AT_external, true),
+			},
+			Children: nil,
+		}
+
+		byName[symbol.Name] = node
+		result = append(result, node)
+	}
+
+	return result, byName
+}
+
+// adds an imported declaration under each subprogram for the
+// variables it reads or writes, the variables are siblings
+func importDataVariables(subprograms []*dwarf.AbbrevTreeNode, routines []routine, references []dataReference, variables map[string]*dwarf.AbbrevTreeNode) {
+	var imported = make(map[string]bool)
+
+	for _, reference := range references {
+		var variable, ok = variables[reference.Symbol]
+		var key = reference.Routine + "\x00" + reference.Symbol
+
+		if !ok || reference.Routine == "" || imported[key] {
+			continue
+		}
+
+		for index, routine := range routines {
+			if routine.Name == reference.Routine {
+				subprograms[index].Children = append(subprograms[index].Children, &dwarf.AbbrevTreeNode{
+					Tag: dwarf.DW_TAG_imported_declaration,
+					Attributes: []dwarf.AbbrevAttr{
+						dwarf.CreateReferenceAttr(dwarf.DW_AT_import, variable),
+					},
+					Children: nil,
+				})
+			}
+		}
+
+		imported[key] = true
+	}
+}
+
+func writeXrefText(output *bytes.Buffer, xrefs []*dataXref) {
+	for _, xref := range xrefs {
+		if len(xref.References) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(output, "%s\n", xref.Name)
+
+		for _, reference := range xref.References {
+			var routine = reference.Routine
+			var symbol = reference.Symbol
+
+			if routine == "" {
+				routine = fmt.Sprintf("0x%03X", reference.Address)
+			}
+
+			if reference.Offset != 0 {
+				symbol += fmt.Sprintf("+0x%X", reference.Offset)
+			}
+
+			var location = reference.File
+
+			if reference.Line != 0 {
+				location += fmt.Sprintf(":%d", reference.Line)
+			}
+
+			fmt.Fprintf(output, "  %-24s %-5s %-24s %s\n", routine, reference.Access, symbol, location)
+		}
+	}
+}
+
+// writes a text report unless the filename ends in .json
+func writeDataXref(filename string, microcodes []*Microcode) error {
+	var output bytes.Buffer
+	var xrefs []*dataXref = nil
+
+	for _, microcode := range microcodes {
+		xrefs = append(xrefs, buildDataXrefs(microcode)...)
+	}
+
+	if !strings.HasSuffix(filename, ".json") {
+		writeXrefText(&output, xrefs)
+	} else {
+		data, err := json.MarshalIndent(xrefs, "", "    ")
+
+		if err != nil {
+			return err
+		}
+
+		output.Write(data)
+		output.WriteByte('\n')
+	}
+
+	return ioutil.WriteFile(filename, output.Bytes(), 0664)
+}